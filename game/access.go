@@ -0,0 +1,152 @@
+package game
+
+import (
+	"context"
+	"time"
+
+	"github.com/sebmartin/collabd/models"
+)
+
+const (
+	// Number of wrong passcodes accepted from a client for a session within PasscodeAttemptWindow,
+	// its further attempts are refused until the oldest of them is out of the window
+	MaxPasscodeAttempts   = 5
+	PasscodeAttemptWindow = time.Minute
+)
+
+var ErrTooManyPasscodeAttempts = NewValidationError(TooManyAttemptsError, "too many wrong passcodes, try again later")
+
+type contextKey string
+
+const clientKey = contextKey("client")
+
+// Context of a request made by the given client, e.g. identified by its address. Wrong passcodes
+// are counted for each client so that one client can't lock the others out of a session.
+func WithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey, client)
+}
+
+// The client that made the request, requests that don't say are all counted as the same client
+func clientFromContext(ctx context.Context) string {
+	client, _ := ctx.Value(clientKey).(string)
+	return client
+}
+
+type passcodeAttemptKey struct {
+	sessionID uint
+	client    string
+}
+
+// What lets a client see a private session, any one of them is enough. Public sessions and private
+// sessions without a passcode can be seen by anyone who has their code.
+type SessionAccess struct {
+	Passcode string
+	// Issued by IssueInvite() for the session
+	Invite string
+	// A player of the session, e.g. authenticated by its token
+	Player *models.Player
+}
+
+// Find the session with the given code if the access lets the client see it, see WithClient()
+func (s *Server) AccessSession(ctx context.Context, code string, access SessionAccess) (*models.Session, error) {
+	session, err := s.SessionForCode(code)
+	if err != nil {
+		return nil, err
+	}
+	if session.IsPublic() {
+		return session, nil
+	}
+
+	if access.Player != nil && access.Player.Session != nil && access.Player.Session.ID == session.ID {
+		return session, nil
+	}
+	if access.Invite != "" {
+		invited, err := s.sessionForInvite(access.Invite)
+		if err != nil {
+			return nil, err
+		}
+		if invited.ID != session.ID {
+			return nil, errInvalidInvite
+		}
+		return session, nil
+	}
+	if err := s.checkPasscode(session, clientFromContext(ctx), access.Passcode); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Check a passcode given for a session by a client. The wrong passcodes each client gives for a
+// session are counted so that guessing a passcode takes too long to be practical, only the attempts
+// that fail count. Other clients, e.g. the actual players, can still use the right passcode.
+func (s *Server) checkPasscode(session *models.Session, client string, passcode string) error {
+	if !session.HasPasscode() {
+		return nil
+	}
+
+	// The attempt is counted before the passcode is checked so that concurrent attempts can't go
+	// over the limit, it is forgotten if the passcode is right
+	key := passcodeAttemptKey{sessionID: session.ID, client: client}
+	attempt, ok := s.startPasscodeAttempt(key)
+	if !ok {
+		return ErrTooManyPasscodeAttempts
+	}
+	if session.CheckPasscode(passcode) {
+		s.forgetPasscodeAttempt(key, attempt)
+		return nil
+	}
+	return ErrInvalidPasscode
+}
+
+func (s *Server) startPasscodeAttempt(key passcodeAttemptKey) (*time.Time, bool) {
+	s.passcodeAttemptsMu.Lock()
+	defer s.passcodeAttemptsMu.Unlock()
+
+	now := s.Clock.Now()
+	var recent []*time.Time
+	for _, attempt := range s.passcodeAttempts[key] {
+		if now.Sub(*attempt) < PasscodeAttemptWindow {
+			recent = append(recent, attempt)
+		}
+	}
+	if len(recent) >= MaxPasscodeAttempts {
+		s.passcodeAttempts[key] = recent
+		return nil, false
+	}
+
+	if s.passcodeAttempts == nil {
+		s.passcodeAttempts = make(map[passcodeAttemptKey][]*time.Time)
+	}
+	attempt := &now
+	s.passcodeAttempts[key] = append(recent, attempt)
+	return attempt, true
+}
+
+func (s *Server) forgetPasscodeAttempt(key passcodeAttemptKey, attempt *time.Time) {
+	s.passcodeAttemptsMu.Lock()
+	defer s.passcodeAttemptsMu.Unlock()
+
+	attempts := s.passcodeAttempts[key]
+	for i, a := range attempts {
+		if a == attempt {
+			attempts = append(attempts[:i], attempts[i+1:]...)
+			break
+		}
+	}
+	if len(attempts) == 0 {
+		delete(s.passcodeAttempts, key)
+	} else {
+		s.passcodeAttempts[key] = attempts
+	}
+}
+
+// Forget the attempts made for a session that was removed from the server
+func (s *Server) forgetPasscodeAttempts(sessionID uint) {
+	s.passcodeAttemptsMu.Lock()
+	defer s.passcodeAttemptsMu.Unlock()
+	for key := range s.passcodeAttempts {
+		if key.sessionID == sessionID {
+			delete(s.passcodeAttempts, key)
+		}
+	}
+}
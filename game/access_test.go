@@ -0,0 +1,131 @@
+package game
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sebmartin/collabd/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_AccessSession(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+
+	public, _ := server.NewSession(context.Background(), testJoinGameName, nil)
+	private, err := server.NewPrivateSession(context.Background(), testJoinGameName, nil, "hunter2")
+	require.Nil(t, err)
+	other, _ := server.NewPrivateSession(context.Background(), testJoinGameName, nil, "")
+	invite, err := server.IssueInvite(private, time.Hour)
+	require.Nil(t, err)
+	otherInvite, err := server.IssueInvite(other, time.Hour)
+	require.Nil(t, err)
+	player, err := server.JoinSession(context.Background(), private.Code, "Annie", "hunter2")
+	require.Nil(t, err)
+	outsider, err := server.JoinSession(context.Background(), other.Code, "Steve", "")
+	require.Nil(t, err)
+
+	tests := []struct {
+		name     string
+		session  *models.Session
+		access   SessionAccess
+		expected error
+	}{
+		{name: "public", session: public},
+		{name: "private without a passcode", session: other},
+		{name: "passcode", session: private, access: SessionAccess{Passcode: "hunter2"}},
+		{name: "invite", session: private, access: SessionAccess{Invite: invite}},
+		{name: "player", session: private, access: SessionAccess{Player: player.Player}},
+		{name: "nothing", session: private, expected: ErrInvalidPasscode},
+		{name: "wrong passcode", session: private, access: SessionAccess{Passcode: "hunter3"}, expected: ErrInvalidPasscode},
+		{name: "invite to another session", session: private, access: SessionAccess{Invite: otherInvite}, expected: errInvalidInvite},
+		{name: "player of another session", session: private, access: SessionAccess{Player: outsider.Player}, expected: ErrInvalidPasscode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, err := server.AccessSession(context.Background(), tt.session.Code, tt.access)
+			if tt.expected != nil {
+				assert.ErrorIs(t, err, tt.expected)
+				return
+			}
+			require.Nil(t, err)
+			assert.Same(t, tt.session, session)
+		})
+	}
+
+	_, err = server.SpectateSession(context.Background(), private.Code, SessionAccess{})
+	assert.ErrorIs(t, err, ErrInvalidPasscode)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err = server.SpectateSession(ctx, private.Code, SessionAccess{Invite: invite})
+	assert.Nil(t, err)
+}
+
+func TestServer_JoinSession_PasscodeAttempts(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+	clock := models.NewFakeClock(time.Now())
+	server.Clock = clock
+
+	session, err := server.NewPrivateSession(context.Background(), testJoinGameName, nil, "hunter2")
+	require.Nil(t, err)
+	other, err := server.NewPrivateSession(context.Background(), testJoinGameName, nil, "hunter2")
+	require.Nil(t, err)
+
+	guesser := WithClient(context.Background(), "203.0.113.1")
+	for i := 0; i < MaxPasscodeAttempts; i++ {
+		clock.Advance(time.Second)
+		_, err := server.JoinSession(guesser, session.Code, "Steve", "guess")
+		assert.ErrorIs(t, err, ErrInvalidPasscode)
+	}
+
+	// Even the right passcode is refused for a while, other sessions are not affected
+	_, err = server.JoinSession(guesser, session.Code, "Steve", "hunter2")
+	assert.ErrorIs(t, err, ErrTooManyPasscodeAttempts)
+	_, err = server.AccessSession(guesser, session.Code, SessionAccess{Passcode: "hunter2"})
+	assert.ErrorIs(t, err, ErrTooManyPasscodeAttempts)
+	_, err = server.AccessSession(guesser, other.Code, SessionAccess{Passcode: "hunter2"})
+	assert.Nil(t, err)
+
+	// The first attempt is out of the window
+	clock.Advance(PasscodeAttemptWindow - time.Duration(MaxPasscodeAttempts-1)*time.Second)
+	_, err = server.JoinSession(guesser, session.Code, "Steve", "guess")
+	assert.ErrorIs(t, err, ErrInvalidPasscode)
+	_, err = server.JoinSession(guesser, session.Code, "Steve", "hunter2")
+	assert.ErrorIs(t, err, ErrTooManyPasscodeAttempts)
+
+	clock.Advance(time.Second)
+	credentials, err := server.JoinSession(guesser, session.Code, "Steve", "hunter2")
+	require.Nil(t, err)
+	assert.Equal(t, session, credentials.Player.Session)
+}
+
+func TestServer_JoinSession_PasscodeAttemptsOfAnotherClient(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+	server.Clock = models.NewFakeClock(time.Now())
+
+	session, err := server.NewPrivateSession(context.Background(), testJoinGameName, nil, "hunter2")
+	require.Nil(t, err)
+
+	guesser := WithClient(context.Background(), "203.0.113.1")
+	for i := 0; i < MaxPasscodeAttempts; i++ {
+		_, err := server.JoinSession(guesser, session.Code, "Steve", "guess")
+		assert.ErrorIs(t, err, ErrInvalidPasscode)
+	}
+	_, err = server.JoinSession(guesser, session.Code, "Steve", "hunter2")
+	require.ErrorIs(t, err, ErrTooManyPasscodeAttempts)
+
+	// The players still get in with the right passcode, they can get a wrong one a few times too
+	player := WithClient(context.Background(), "198.51.100.7")
+	_, err = server.AccessSession(player, session.Code, SessionAccess{Passcode: "hunter3"})
+	assert.ErrorIs(t, err, ErrInvalidPasscode)
+	accessed, err := server.AccessSession(player, session.Code, SessionAccess{Passcode: "hunter2"})
+	require.Nil(t, err)
+	assert.Same(t, session, accessed)
+	credentials, err := server.JoinSession(player, session.Code, "Annie", "hunter2")
+	require.Nil(t, err)
+	assert.Equal(t, session, credentials.Player.Session)
+}
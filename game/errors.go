@@ -0,0 +1,37 @@
+package game
+
+import "fmt"
+
+const (
+	UnknownEventTypeError    = "UNKNOWN_EVENT_TYPE"
+	InvalidPayloadError      = "INVALID_PAYLOAD"
+	InvalidPasscodeError     = "INVALID_PASSCODE"
+	InvalidInviteError       = "INVALID_INVITE"
+	TooManyAttemptsError     = "TOO_MANY_ATTEMPTS"
+	GameStartedError         = "GAME_STARTED"
+	NotAcceptingPlayersError = "NOT_ACCEPTING_PLAYERS"
+)
+
+// An error returned when a client request fails validation. The code is exposed to GraphQL
+// clients as an error extension so they don't have to parse the message.
+type ValidationError struct {
+	Code    string
+	Message string
+}
+
+func NewValidationError(code string, format string, args ...interface{}) *ValidationError {
+	return &ValidationError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func (e *ValidationError) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"code": e.Code,
+	}
+}
@@ -0,0 +1,150 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/sebmartin/collabd/game/join_stage"
+	"github.com/sebmartin/collabd/models"
+)
+
+// Decoders for the events handled by sessions and by the stages that are provided by this package.
+// Joining a session has its own mutation so only the events sent by players who have already joined
+// are registered here.
+func init() {
+	RegisterEvent(models.ChatEventType, func(ctx context.Context, sender *models.Player, payload json.RawMessage) (models.PlayerEvent, error) {
+		var args struct {
+			Message string           `json:"message"`
+			Scope   models.ChatScope `json:"scope"`
+		}
+		if err := DecodePayload(payload, &args); err != nil {
+			return nil, err
+		}
+		if args.Message == "" {
+			return nil, NewValidationError(InvalidPayloadError, "invalid payload: message is required")
+		}
+		if args.Scope == "" {
+			args.Scope = models.ChatPublic
+		}
+		return models.NewChatEvent(ctx, sender, args.Message, args.Scope), nil
+	})
+	RegisterEvent(join_stage.StartEventType, func(ctx context.Context, sender *models.Player, payload json.RawMessage) (models.PlayerEvent, error) {
+		if err := DecodePayload(payload, &struct{}{}); err != nil {
+			return nil, err
+		}
+		return join_stage.NewStartEvent(ctx, sender), nil
+	})
+	RegisterEvent(join_stage.LeaveEventType, func(ctx context.Context, sender *models.Player, payload json.RawMessage) (models.PlayerEvent, error) {
+		if err := DecodePayload(payload, &struct{}{}); err != nil {
+			return nil, err
+		}
+		return join_stage.NewLeaveEvent(ctx, sender), nil
+	})
+	RegisterEvent(join_stage.ReadyEventType, func(ctx context.Context, sender *models.Player, payload json.RawMessage) (models.PlayerEvent, error) {
+		var args struct {
+			Ready *bool `json:"ready"`
+		}
+		if err := DecodePayload(payload, &args); err != nil {
+			return nil, err
+		}
+		if args.Ready == nil {
+			return nil, NewValidationError(InvalidPayloadError, "invalid payload: ready is required")
+		}
+		return join_stage.NewReadyEvent(ctx, sender, *args.Ready), nil
+	})
+	RegisterEvent(join_stage.ChooseTeamEventType, func(ctx context.Context, sender *models.Player, payload json.RawMessage) (models.PlayerEvent, error) {
+		var args struct {
+			Team string `json:"team"`
+		}
+		if err := DecodePayload(payload, &args); err != nil {
+			return nil, err
+		}
+		if args.Team == "" {
+			return nil, NewValidationError(InvalidPayloadError, "invalid payload: team is required")
+		}
+		return join_stage.NewChooseTeamEvent(ctx, sender, args.Team), nil
+	})
+	RegisterEvent(join_stage.SeatOrderEventType, func(ctx context.Context, sender *models.Player, payload json.RawMessage) (models.PlayerEvent, error) {
+		playerIDs, err := decodePlayerIDs(payload)
+		if err != nil {
+			return nil, err
+		}
+		return join_stage.NewSeatOrderEvent(ctx, sender, playerIDs), nil
+	})
+	RegisterEvent(join_stage.KickEventType, func(ctx context.Context, sender *models.Player, payload json.RawMessage) (models.PlayerEvent, error) {
+		playerID, err := decodePlayerID(payload)
+		if err != nil {
+			return nil, err
+		}
+		return join_stage.NewKickEvent(ctx, sender, playerID), nil
+	})
+	RegisterEvent(join_stage.LockEventType, func(ctx context.Context, sender *models.Player, payload json.RawMessage) (models.PlayerEvent, error) {
+		var args struct {
+			Locked *bool `json:"locked"`
+		}
+		if err := DecodePayload(payload, &args); err != nil {
+			return nil, err
+		}
+		if args.Locked == nil {
+			return nil, NewValidationError(InvalidPayloadError, "invalid payload: locked is required")
+		}
+		return join_stage.NewLockEvent(ctx, sender, *args.Locked), nil
+	})
+	RegisterEvent(join_stage.TransferHostEventType, func(ctx context.Context, sender *models.Player, payload json.RawMessage) (models.PlayerEvent, error) {
+		playerID, err := decodePlayerID(payload)
+		if err != nil {
+			return nil, err
+		}
+		return join_stage.NewTransferHostEvent(ctx, sender, playerID), nil
+	})
+}
+
+// Decode a payload that designates a player, its ID is a string like the IDs of the GraphQL API
+// but a number is accepted as well
+func decodePlayerID(payload json.RawMessage) (uint, error) {
+	var args struct {
+		PlayerID interface{} `json:"playerId"`
+	}
+	if err := DecodePayload(payload, &args); err != nil {
+		return 0, err
+	}
+	if args.PlayerID == nil {
+		return 0, NewValidationError(InvalidPayloadError, "invalid payload: playerId is required")
+	}
+	return unmarshalPlayerID(args.PlayerID)
+}
+
+func decodePlayerIDs(payload json.RawMessage) ([]uint, error) {
+	var args struct {
+		PlayerIDs []interface{} `json:"playerIds"`
+	}
+	if err := DecodePayload(payload, &args); err != nil {
+		return nil, err
+	}
+	if args.PlayerIDs == nil {
+		return nil, NewValidationError(InvalidPayloadError, "invalid payload: playerIds is required")
+	}
+
+	ids := make([]uint, 0, len(args.PlayerIDs))
+	for _, v := range args.PlayerIDs {
+		id, err := unmarshalPlayerID(v)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func unmarshalPlayerID(v interface{}) (uint, error) {
+	if number, ok := v.(float64); ok {
+		// Numbers are decoded as floats, they would otherwise be formatted with decimals
+		v = json.Number(strconv.FormatFloat(number, 'f', -1, 64))
+	}
+	id, err := models.UnmarshalID(v)
+	if err != nil {
+		return 0, NewValidationError(InvalidPayloadError, "invalid payload: %s", err)
+	}
+	return id, nil
+}
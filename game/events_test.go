@@ -0,0 +1,64 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/sebmartin/collabd/game/join_stage"
+	"github.com/sebmartin/collabd/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeEvent_LobbyControls(t *testing.T) {
+	event, err := DecodeEvent(context.Background(), join_stage.KickEventType, nil, json.RawMessage(`{"playerId": "12"}`))
+	require.Nil(t, err)
+	assert.Equal(t, uint(12), event.(*join_stage.KickEvent).PlayerID)
+
+	event, err = DecodeEvent(context.Background(), join_stage.TransferHostEventType, nil, json.RawMessage(`{"playerId": 7}`))
+	require.Nil(t, err)
+	assert.Equal(t, uint(7), event.(*join_stage.TransferHostEvent).PlayerID)
+
+	event, err = DecodeEvent(context.Background(), join_stage.LockEventType, nil, json.RawMessage(`{"locked": false}`))
+	require.Nil(t, err)
+	assert.False(t, event.(*join_stage.LockEvent).Locked)
+}
+
+func TestDecodeEvent_LobbyControls_Invalid(t *testing.T) {
+	_, err := DecodeEvent(context.Background(), join_stage.KickEventType, nil, nil)
+	assert.ErrorContains(t, err, "playerId is required")
+	_, err = DecodeEvent(context.Background(), join_stage.KickEventType, nil, json.RawMessage(`{"playerId": "steve"}`))
+	assert.ErrorContains(t, err, "invalid id: steve")
+	_, err = DecodeEvent(context.Background(), join_stage.LockEventType, nil, json.RawMessage(`{}`))
+	assert.ErrorContains(t, err, "locked is required")
+}
+
+func TestDecodeEvent_Roster(t *testing.T) {
+	event, err := DecodeEvent(context.Background(), join_stage.SeatOrderEventType, nil, json.RawMessage(`{"playerIds": ["3", 1]}`))
+	require.Nil(t, err)
+	assert.Equal(t, []uint{3, 1}, event.(*join_stage.SeatOrderEvent).PlayerIDs)
+
+	event, err = DecodeEvent(context.Background(), join_stage.ChooseTeamEventType, nil, json.RawMessage(`{"team": "red"}`))
+	require.Nil(t, err)
+	assert.Equal(t, "red", event.(*join_stage.ChooseTeamEvent).Team)
+
+	_, err = DecodeEvent(context.Background(), join_stage.SeatOrderEventType, nil, json.RawMessage(`{}`))
+	assert.ErrorContains(t, err, "playerIds is required")
+	_, err = DecodeEvent(context.Background(), join_stage.ChooseTeamEventType, nil, json.RawMessage(`{}`))
+	assert.ErrorContains(t, err, "team is required")
+}
+
+func TestDecodeEvent_Chat(t *testing.T) {
+	event, err := DecodeEvent(context.Background(), models.ChatEventType, nil, json.RawMessage(`{"message": "Hello"}`))
+	require.Nil(t, err)
+	assert.Equal(t, "Hello", event.(*models.ChatEvent).Message)
+	assert.Equal(t, models.ChatPublic, event.(*models.ChatEvent).Scope, "Messages should be public by default")
+
+	event, err = DecodeEvent(context.Background(), models.ChatEventType, nil, json.RawMessage(`{"message": "Go left", "scope": "TEAM"}`))
+	require.Nil(t, err)
+	assert.Equal(t, models.ChatTeam, event.(*models.ChatEvent).Scope)
+
+	_, err = DecodeEvent(context.Background(), models.ChatEventType, nil, json.RawMessage(`{"scope": "TEAM"}`))
+	assert.ErrorContains(t, err, "message is required")
+}
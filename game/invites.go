@@ -0,0 +1,73 @@
+package game
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sebmartin/collabd/models"
+)
+
+const (
+	// Amount of time an invite remains valid when no other is given
+	DefaultInviteTTL = 24 * time.Hour
+	// Invites are signed with the same secret as player tokens, the prefix keeps one from being
+	// accepted as the other
+	inviteSignaturePrefix = "invite."
+)
+
+var (
+	ErrInvalidPasscode = NewValidationError(InvalidPasscodeError, "invalid passcode")
+	errInvalidInvite   = NewValidationError(InvalidInviteError, "invalid invite")
+	errExpiredInvite   = NewValidationError(InvalidInviteError, "the invite has expired")
+)
+
+type inviteClaims struct {
+	SessionID uint  `json:"s"`
+	ExpiresAt int64 `json:"e"`
+}
+
+// Issue an invite to a session that lets anyone who has it join the session without its
+// passcode until the invite expires. Like player tokens, invites are opaque to clients and are
+// signed with the server's token secret.
+func (s *Server) IssueInvite(session *models.Session, ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		return "", fmt.Errorf("invites must remain valid for some time")
+	}
+
+	claims, err := json.Marshal(inviteClaims{
+		SessionID: session.ID,
+		ExpiresAt: s.Clock.Now().Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(claims)
+	return encoded + "." + s.sign(inviteSignaturePrefix+encoded), nil
+}
+
+// Find the session that an invite issued by IssueInvite was issued for
+func (s *Server) sessionForInvite(invite string) (*models.Session, error) {
+	encoded, signature, found := strings.Cut(invite, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(s.sign(inviteSignaturePrefix+encoded))) {
+		return nil, errInvalidInvite
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errInvalidInvite
+	}
+	var claims inviteClaims
+	if err := json.Unmarshal(decoded, &claims); err != nil {
+		return nil, errInvalidInvite
+	}
+	if !s.Clock.Now().Before(time.Unix(claims.ExpiresAt, 0)) {
+		return nil, errExpiredInvite
+	}
+
+	return s.SessionForID(claims.SessionID)
+}
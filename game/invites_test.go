@@ -0,0 +1,125 @@
+package game
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sebmartin/collabd/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_PrivateSession_Passcode(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+
+	session, err := server.NewPrivateSession(context.Background(), testJoinGameName, nil, "hunter2")
+	require.Nil(t, err)
+	assert.False(t, session.IsPublic())
+	assert.True(t, session.HasPasscode())
+	assert.NotContains(t, session.PasscodeHash, "hunter2")
+
+	_, err = server.JoinSession(context.Background(), session.Code, "Steve", "")
+	assert.ErrorIs(t, err, ErrInvalidPasscode)
+	_, err = server.JoinSession(context.Background(), session.Code, "Steve", "hunter3")
+	assert.ErrorIs(t, err, ErrInvalidPasscode)
+	assert.Empty(t, session.Players)
+
+	credentials, err := server.JoinSession(context.Background(), session.Code, "Steve", "hunter2")
+	require.Nil(t, err)
+	assert.Equal(t, session, credentials.Player.Session)
+}
+
+func TestServer_JoinSessionWithInvite(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+	clock := models.NewFakeClock(time.Now())
+	server.Clock = clock
+
+	session, err := server.NewPrivateSession(context.Background(), testJoinGameName, nil, "hunter2")
+	require.Nil(t, err)
+	invite, err := server.IssueInvite(session, time.Hour)
+	require.Nil(t, err)
+
+	credentials, err := server.JoinSessionWithInvite(context.Background(), invite, "Steve")
+	require.Nil(t, err, "Invites should not require the passcode")
+	assert.Equal(t, session, credentials.Player.Session)
+}
+
+func TestServer_JoinSessionWithInvite_Expired(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+	clock := models.NewFakeClock(time.Now())
+	server.Clock = clock
+
+	session, _ := server.NewPrivateSession(context.Background(), testJoinGameName, nil, "")
+	invite, err := server.IssueInvite(session, time.Hour)
+	require.Nil(t, err)
+
+	clock.Advance(time.Hour)
+	_, err = server.JoinSessionWithInvite(context.Background(), invite, "Steve")
+	assert.ErrorIs(t, err, errExpiredInvite)
+	assert.Empty(t, session.Players)
+}
+
+func TestServer_JoinSessionWithInvite_Invalid(t *testing.T) {
+	server, session, cleanup := newServerSession(t)
+	defer cleanup()
+
+	invite, err := server.IssueInvite(session, time.Hour)
+	require.Nil(t, err)
+	player := newPlayer(server.db, "Annie")
+	session.AddPlayer(player)
+	token, err := server.IssueToken(player)
+	require.Nil(t, err)
+
+	tests := []struct {
+		name   string
+		invite string
+	}{
+		{name: "empty", invite: ""},
+		{name: "no signature", invite: "abc"},
+		{name: "tampered claims", invite: "x" + invite},
+		{name: "tampered signature", invite: invite + "x"},
+		{name: "player token", invite: token},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := server.JoinSessionWithInvite(context.Background(), tt.invite, "Steve")
+			assert.ErrorIs(t, err, errInvalidInvite)
+		})
+	}
+
+	_, err = server.IssueInvite(session, 0)
+	assert.ErrorContains(t, err, "invites must remain valid for some time")
+}
+
+func TestServer_ListedSessions(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+
+	open, _ := server.NewSession(context.Background(), testJoinGameName, nil)
+	full, _ := server.NewSession(context.Background(), testJoinGameName, nil)
+	_, err := server.JoinSession(context.Background(), full.Code, "Steve", "")
+	require.Nil(t, err)
+	_, err = server.NewPrivateSession(context.Background(), testJoinGameName, nil, "")
+	require.Nil(t, err)
+
+	listed, err := server.ListedSessions(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, []*models.Session{open}, listed)
+}
+
+func TestServer_PrivateSession_Restore(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+
+	session, _ := server.NewPrivateSession(context.Background(), testJoinGameName, nil, "hunter2")
+	sessions, err := models.LiveSessions(server.db)
+	require.Nil(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, session.ID, sessions[0].ID)
+	assert.Equal(t, models.SessionPrivate, sessions[0].Visibility)
+	assert.True(t, sessions[0].CheckPasscode("hunter2"), "The passcode should be kept across restarts")
+}
@@ -2,6 +2,7 @@ package join_stage
 
 import (
 	"context"
+	"time"
 
 	"github.com/sebmartin/collabd/models"
 )
@@ -11,6 +12,25 @@ const (
 	DidJoinEventType  models.EventType = "DID_JOIN"
 	StartEventType    models.EventType = "START"
 	DidStartEventType models.EventType = "DID_START"
+
+	LeaveEventType        models.EventType = "LEAVE"
+	DidLeaveEventType     models.EventType = "DID_LEAVE"
+	KickEventType         models.EventType = "KICK"
+	DidKickEventType      models.EventType = "DID_KICK"
+	LockEventType         models.EventType = "LOCK"
+	DidLockEventType      models.EventType = "DID_LOCK"
+	TransferHostEventType models.EventType = "TRANSFER_HOST"
+	HostChangedEventType  models.EventType = "HOST_CHANGED"
+
+	ReadyEventType                   models.EventType = "READY"
+	DidReadyEventType                models.EventType = "DID_READY"
+	StartCountdownEventType          models.EventType = "START_COUNTDOWN"
+	StartCountdownCancelledEventType models.EventType = "START_COUNTDOWN_CANCELLED"
+
+	ChooseTeamEventType    models.EventType = "CHOOSE_TEAM"
+	DidChooseTeamEventType models.EventType = "DID_CHOOSE_TEAM"
+	SeatOrderEventType     models.EventType = "SET_SEAT_ORDER"
+	DidSeatOrderEventType  models.EventType = "DID_SET_SEAT_ORDER"
 )
 
 // Send a JoinEvent to add player to the game. A join request can be refused if the game has already started
@@ -25,20 +45,25 @@ func NewJoinEvent(ctx context.Context, sender *models.Player) *JoinEvent {
 	}
 }
 
+// Sent to every player of the lobby when a player joins, including the player who joined
 type DidJoinEvent struct {
 	models.ServerEvent
 
 	Player *models.Player
+	// The session's host, the first player to join
+	Host *models.Player
 }
 
-func NewDidJoinEvent(player *models.Player) *DidJoinEvent {
+func NewDidJoinEvent(player *models.Player, host *models.Player) *DidJoinEvent {
 	return &DidJoinEvent{
 		ServerEvent: models.NewServerEvent(DidJoinEventType),
 		Player:      player,
+		Host:        host,
 	}
 }
 
-// Send a StartEvent to indicate that all players have joined and the game is ready to begin
+// Send a StartEvent to indicate that all players have joined and the game is ready to begin. Only
+// the host can start the game.
 type StartEvent struct {
 	models.PlayerEvent
 }
@@ -49,12 +74,241 @@ func NewStartEvent(ctx context.Context, sender *models.Player) *StartEvent {
 	}
 }
 
+// Sent when the game starts, it tells players where they sit and which team they are on
 type DidStartEvent struct {
 	models.ServerEvent
+
+	Seats []Seat
 }
 
-func NewDidStartEvent(players []*models.Player) *DidStartEvent {
+func NewDidStartEvent(roster *Roster) *DidStartEvent {
 	return &DidStartEvent{
 		ServerEvent: models.NewServerEvent(DidStartEventType),
+		Seats:       roster.Seats,
+	}
+}
+
+// Send a LeaveEvent to leave the lobby before the game starts
+type LeaveEvent struct {
+	models.PlayerEvent
+}
+
+func NewLeaveEvent(ctx context.Context, sender *models.Player) *LeaveEvent {
+	return &LeaveEvent{
+		PlayerEvent: models.NewPlayerEvent(ctx, LeaveEventType, sender),
+	}
+}
+
+// Sent to the players of the lobby, including the one that left
+type DidLeaveEvent struct {
+	models.ServerEvent
+
+	Player *models.Player
+}
+
+func NewDidLeaveEvent(player *models.Player) *DidLeaveEvent {
+	return &DidLeaveEvent{
+		ServerEvent: models.NewServerEvent(DidLeaveEventType),
+		Player:      player,
+	}
+}
+
+// Sent by the host to remove a player from the lobby
+type KickEvent struct {
+	models.PlayerEvent
+
+	PlayerID uint
+}
+
+func NewKickEvent(ctx context.Context, sender *models.Player, playerID uint) *KickEvent {
+	return &KickEvent{
+		PlayerEvent: models.NewPlayerEvent(ctx, KickEventType, sender),
+		PlayerID:    playerID,
+	}
+}
+
+// Sent to the players of the lobby, including the one that was kicked
+type DidKickEvent struct {
+	models.ServerEvent
+
+	Player *models.Player
+}
+
+func NewDidKickEvent(player *models.Player) *DidKickEvent {
+	return &DidKickEvent{
+		ServerEvent: models.NewServerEvent(DidKickEventType),
+		Player:      player,
+	}
+}
+
+// Sent by the host to stop or allow players from joining the lobby
+type LockEvent struct {
+	models.PlayerEvent
+
+	Locked bool
+}
+
+func NewLockEvent(ctx context.Context, sender *models.Player, locked bool) *LockEvent {
+	return &LockEvent{
+		PlayerEvent: models.NewPlayerEvent(ctx, LockEventType, sender),
+		Locked:      locked,
+	}
+}
+
+type DidLockEvent struct {
+	models.ServerEvent
+
+	Locked bool
+}
+
+func NewDidLockEvent(locked bool) *DidLockEvent {
+	return &DidLockEvent{
+		ServerEvent: models.NewServerEvent(DidLockEventType),
+		Locked:      locked,
+	}
+}
+
+// Sent by the host to hand the role over to another player of the lobby
+type TransferHostEvent struct {
+	models.PlayerEvent
+
+	PlayerID uint
+}
+
+func NewTransferHostEvent(ctx context.Context, sender *models.Player, playerID uint) *TransferHostEvent {
+	return &TransferHostEvent{
+		PlayerEvent: models.NewPlayerEvent(ctx, TransferHostEventType, sender),
+		PlayerID:    playerID,
+	}
+}
+
+// Sent to the players of the lobby when the host changes, either because it was transferred or
+// because the host left
+type HostChangedEvent struct {
+	models.ServerEvent
+
+	Host *models.Player
+}
+
+func NewHostChangedEvent(host *models.Player) *HostChangedEvent {
+	return &HostChangedEvent{
+		ServerEvent: models.NewServerEvent(HostChangedEventType),
+		Host:        host,
+	}
+}
+
+// Send a ReadyEvent to tell the other players whether you are ready to start the game. In a lobby
+// with a ready check the game starts once every player is ready.
+type ReadyEvent struct {
+	models.PlayerEvent
+
+	Ready bool
+}
+
+func NewReadyEvent(ctx context.Context, sender *models.Player, ready bool) *ReadyEvent {
+	return &ReadyEvent{
+		PlayerEvent: models.NewPlayerEvent(ctx, ReadyEventType, sender),
+		Ready:       ready,
+	}
+}
+
+type DidReadyEvent struct {
+	models.ServerEvent
+
+	Player *models.Player
+	Ready  bool
+}
+
+func NewDidReadyEvent(player *models.Player, ready bool) *DidReadyEvent {
+	return &DidReadyEvent{
+		ServerEvent: models.NewServerEvent(DidReadyEventType),
+		Player:      player,
+		Ready:       ready,
+	}
+}
+
+// Sent when every player is ready, the game starts once the countdown ends
+type StartCountdownEvent struct {
+	models.ServerEvent
+
+	Duration time.Duration
+}
+
+func NewStartCountdownEvent(duration time.Duration) *StartCountdownEvent {
+	return &StartCountdownEvent{
+		ServerEvent: models.NewServerEvent(StartCountdownEventType),
+		Duration:    duration,
+	}
+}
+
+// Length of the countdown in whole seconds
+func (e *StartCountdownEvent) Seconds() int {
+	return int(e.Duration / time.Second)
+}
+
+// Sent when a player is no longer ready during the countdown, or the lobby changed
+type StartCountdownCancelledEvent struct {
+	models.ServerEvent
+}
+
+func NewStartCountdownCancelledEvent() *StartCountdownCancelledEvent {
+	return &StartCountdownCancelledEvent{
+		ServerEvent: models.NewServerEvent(StartCountdownCancelledEventType),
+	}
+}
+
+// Send a ChooseTeamEvent to join one of the game's teams, when players pick their team
+type ChooseTeamEvent struct {
+	models.PlayerEvent
+
+	Team string
+}
+
+func NewChooseTeamEvent(ctx context.Context, sender *models.Player, team string) *ChooseTeamEvent {
+	return &ChooseTeamEvent{
+		PlayerEvent: models.NewPlayerEvent(ctx, ChooseTeamEventType, sender),
+		Team:        team,
+	}
+}
+
+type DidChooseTeamEvent struct {
+	models.ServerEvent
+
+	Player *models.Player
+	Team   string
+}
+
+func NewDidChooseTeamEvent(player *models.Player, team string) *DidChooseTeamEvent {
+	return &DidChooseTeamEvent{
+		ServerEvent: models.NewServerEvent(DidChooseTeamEventType),
+		Player:      player,
+		Team:        team,
+	}
+}
+
+// Sent by the host to choose the turn order, when the host picks the seats
+type SeatOrderEvent struct {
+	models.PlayerEvent
+
+	PlayerIDs []uint
+}
+
+func NewSeatOrderEvent(ctx context.Context, sender *models.Player, playerIDs []uint) *SeatOrderEvent {
+	return &SeatOrderEvent{
+		PlayerEvent: models.NewPlayerEvent(ctx, SeatOrderEventType, sender),
+		PlayerIDs:   playerIDs,
+	}
+}
+
+type DidSeatOrderEvent struct {
+	models.ServerEvent
+
+	Players []*models.Player
+}
+
+func NewDidSeatOrderEvent(players []*models.Player) *DidSeatOrderEvent {
+	return &DidSeatOrderEvent{
+		ServerEvent: models.NewServerEvent(DidSeatOrderEventType),
+		Players:     players,
 	}
 }
@@ -1,65 +1,417 @@
 package join_stage
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/sebmartin/collabd/models"
 )
 
 const (
 	InitialPlayerArraySize = 10
+	// Name of the lobby stage in session snapshots
+	StageName = "join"
 )
 
 type JoinGame struct {
 	MinPlayers uint
 	MaxPlayers uint
-	StartGame  func([]*models.Player) models.StageRunner
+	// Creates the game's next stage once the game starts, the roster lists the players in turn
+	// order along with their teams
+	StartGame func(*Roster) models.StageRunner
+
+	// Start the game once every player is ready and there are enough of them, see ReadyEvent
+	ReadyCheck bool
+	// Time left to players to change their mind once they are all ready, any player who is no
+	// longer ready cancels the countdown. The game starts right away when zero.
+	StartCountdown time.Duration
+	// Start the game as soon as MaxPlayers have joined
+	AutoStartWhenFull bool
+
+	// How the turn order is decided when the game starts
+	Seating SeatingPolicy
+	// Names of the game's teams, the game has no teams when empty
+	Teams          []string
+	TeamAssignment TeamAssignment
+	// Shuffles the players for random seats and teams, math/rand is used when nil
+	Shuffle func(n int, swap func(i, j int))
 
 	players []*models.Player
+	ready   map[*models.Player]bool
+	timers  models.Timers
+	// Set while the countdown to start the game is running
+	countingDown bool
+	// Teams picked by players and seats picked by the host, depending on the policies
+	teamChoices map[*models.Player]string
+	seatOrder   []*models.Player
+	// Set once the game starts
+	roster *Roster
+	// The first player to join, only the host can start the game and manage the lobby
+	host *models.Player
+	// No one can join a locked lobby
+	locked bool
 }
 
 func (g *JoinGame) Run(playerEvents <-chan models.PlayerEvent) models.StageRunner {
-	for event := range playerEvents {
-		switch event := event.(type) {
+	next, _ := g.RunContext(context.Background(), playerEvents)
+	return next
+}
+
+// Run until the game starts. The stage ends without a next stage when the session is aborted or
+// its event channel is closed before the game starts.
+func (g *JoinGame) RunContext(ctx context.Context, playerEvents <-chan models.PlayerEvent) (models.StageRunner, error) {
+	// A restored lobby may already be ready to start
+	if next := checkAutoStart(g); next != nil {
+		return next, nil
+	}
+
+	for {
+		select {
+		case event, ok := <-playerEvents:
+			if !ok {
+				return nil, nil
+			}
+			switch event := event.(type) {
+
+			case *JoinEvent:
+				handleJoin(event, g)
+
+			case *LeaveEvent:
+				handleLeave(event, g)
+
+			case *KickEvent:
+				handleKick(event, g)
+
+			case *LockEvent:
+				handleLock(event, g)
+
+			case *TransferHostEvent:
+				handleTransferHost(event, g)
+
+			case *ReadyEvent:
+				handleReady(event, g)
+
+			case *ChooseTeamEvent:
+				handleChooseTeam(event, g)
+
+			case *SeatOrderEvent:
+				handleSeatOrder(event, g)
 
-		case *JoinEvent:
-			handleJoin(event, g)
+			case *models.TimerEvent:
+				if event.Name == countdownTimer && g.countingDown {
+					g.countingDown = false
+					return startGame(g), nil
+				}
 
-		case *StartEvent:
-			next := handleStart(event, g)
-			if next != nil {
-				return next
+			case *StartEvent:
+				next := handleStart(event, g)
+				if next != nil {
+					return next, nil
+				}
 			}
+
+			// Any change to the lobby may complete or break the conditions to start the game
+			if next := checkAutoStart(g); next != nil {
+				return next, nil
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
 	}
+}
+
+type joinGameSnapshot struct {
+	MinPlayers uint         `json:"minPlayers"`
+	MaxPlayers uint         `json:"maxPlayers"`
+	Players    []uint       `json:"players"`
+	Host       uint         `json:"host,omitempty"`
+	Locked     bool         `json:"locked,omitempty"`
+	Ready      []uint       `json:"ready,omitempty"`
+	Teams      []teamChoice `json:"teams,omitempty"`
+	SeatOrder  []uint       `json:"seatOrder,omitempty"`
+}
+
+type teamChoice struct {
+	Player uint   `json:"player"`
+	Team   string `json:"team"`
+}
+
+// Players who chose the same team as the player, the teams of the players who did not choose
+// are only known once the game starts. The session keeps the teams of the roster for the game's
+// next stages.
+func (g *JoinGame) Teammates(player *models.Player) []*models.Player {
+	if g.roster != nil {
+		return g.roster.Teammates(player)
+	}
+	team, ok := g.teamChoices[player]
+	if !ok {
+		return nil
+	}
+
+	var teammates []*models.Player
+	for _, p := range g.players {
+		if g.teamChoices[p] == team {
+			teammates = append(teammates, p)
+		}
+	}
+	return teammates
+}
 
-	panic("JoinGame stage's event loop ended without a StartEvent")
+// Whether another player can join the lobby, see models.LobbyStage
+func (g *JoinGame) AcceptingPlayers() bool {
+	return !g.locked && len(g.players) < int(g.MaxPlayers)
+}
+
+func (g *JoinGame) StageName() string {
+	return StageName
+}
+
+func (g *JoinGame) Snapshot() interface{} {
+	snapshot := joinGameSnapshot{
+		MinPlayers: g.MinPlayers,
+		MaxPlayers: g.MaxPlayers,
+		Players:    make([]uint, 0, len(g.players)),
+		Locked:     g.locked,
+	}
+	for _, p := range g.players {
+		snapshot.Players = append(snapshot.Players, p.ID)
+		if g.ready[p] {
+			snapshot.Ready = append(snapshot.Ready, p.ID)
+		}
+		if team, ok := g.teamChoices[p]; ok {
+			snapshot.Teams = append(snapshot.Teams, teamChoice{Player: p.ID, Team: team})
+		}
+	}
+	if g.host != nil {
+		snapshot.Host = g.host.ID
+	}
+	for _, p := range g.seatOrder {
+		if isMember(g, p) {
+			snapshot.SeatOrder = append(snapshot.SeatOrder, p.ID)
+		}
+	}
+	return snapshot
+}
+
+// Restore the players that had joined from a snapshot taken by Snapshot(). The stage's player
+// limits are not restored, they are part of how the game configures the stage.
+func (g *JoinGame) Restore(state json.RawMessage, players []*models.Player) error {
+	var snapshot joinGameSnapshot
+	if err := json.Unmarshal(state, &snapshot); err != nil {
+		return err
+	}
+
+	g.players = make([]*models.Player, 0, InitialPlayerArraySize)
+	for _, id := range snapshot.Players {
+		player, err := models.FindPlayer(players, id)
+		if err != nil {
+			return err
+		}
+		g.players = append(g.players, player)
+	}
+
+	// A countdown that was running is not restored, it starts over once the stage runs
+	g.ready = make(map[*models.Player]bool)
+	for _, id := range snapshot.Ready {
+		player, err := models.FindPlayer(g.players, id)
+		if err != nil {
+			return err
+		}
+		g.ready[player] = true
+	}
+
+	g.teamChoices = make(map[*models.Player]string)
+	for _, choice := range snapshot.Teams {
+		player, err := models.FindPlayer(g.players, choice.Player)
+		if err != nil {
+			return err
+		}
+		g.teamChoices[player] = choice.Team
+	}
+	g.seatOrder = nil
+	for _, id := range snapshot.SeatOrder {
+		player, err := models.FindPlayer(g.players, id)
+		if err != nil {
+			return err
+		}
+		g.seatOrder = append(g.seatOrder, player)
+	}
+
+	g.host, g.locked = nil, snapshot.Locked
+	if snapshot.Host != 0 {
+		host, err := models.FindPlayer(g.players, snapshot.Host)
+		if err != nil {
+			return err
+		}
+		g.host = host
+	}
+	return nil
 }
 
 func handleJoin(event *JoinEvent, stage *JoinGame) {
+	if stage.locked {
+		event.Sender().Send(models.NewErrorEvent(fmt.Errorf("the lobby is locked")))
+		return
+	}
+	for _, p := range stage.players {
+		if isSamePlayer(p, event.Sender()) {
+			event.Sender().Send(models.NewErrorEvent(fmt.Errorf("player %s has already joined", p.Name)))
+			return
+		}
+		if strings.EqualFold(p.Name, event.Sender().Name) {
+			event.Sender().Send(models.NewErrorEvent(fmt.Errorf(`the name "%s" is already taken`, event.Sender().Name)))
+			return
+		}
+	}
 	if len(stage.players) >= int(stage.MaxPlayers) {
-		event.Sender().ServerEvents <- models.NewErrorEvent(
+		event.Sender().Send(models.NewErrorEvent(
 			fmt.Errorf("maximum player count of %d has already been reached", stage.MaxPlayers),
-		)
+		))
 		return
 	}
 	if stage.players == nil {
 		stage.players = make([]*models.Player, 0, InitialPlayerArraySize)
 	}
 	stage.players = append(stage.players, event.Sender())
-	event.Sender().ServerEvents <- NewDidJoinEvent(event.Sender())
+	if stage.host == nil {
+		stage.host = event.Sender()
+	}
+	// The player is part of the session before anyone hears of it, e.g. before the host kicks them
+	if session := event.Sender().Session; session != nil {
+		session.AddPlayer(event.Sender())
+	}
+	models.Broadcast(stage.players, NewDidJoinEvent(event.Sender(), stage.host))
+}
+
+// Players are the same if they have the same ID, players that were not saved are compared by
+// reference
+func isSamePlayer(a *models.Player, b *models.Player) bool {
+	if a.Model != nil && b.Model != nil {
+		return a.ID == b.ID
+	}
+	return a == b
+}
+
+func handleLeave(event *LeaveEvent, stage *JoinGame) {
+	player := event.Sender()
+	if !isMember(stage, player) {
+		player.Send(models.NewErrorEvent(errNotJoined(player)))
+		return
+	}
+
+	models.Broadcast(stage.players, NewDidLeaveEvent(player))
+	removePlayer(stage, player)
+	if player.Session != nil {
+		player.Session.RemovePlayer(player)
+	}
+}
+
+func errNotJoined(player *models.Player) error {
+	return fmt.Errorf("player %s has not joined the lobby", player.Name)
+}
+
+func isMember(stage *JoinGame, player *models.Player) bool {
+	for _, p := range stage.players {
+		if p == player {
+			return true
+		}
+	}
+	return false
 }
 
 func handleStart(event *StartEvent, stage *JoinGame) models.StageRunner {
+	if !requireHost(event, stage, "start the game") {
+		return nil
+	}
 	if len(stage.players) < int(stage.MinPlayers) {
-		event.Sender().ServerEvents <- models.NewErrorEvent(
+		event.Sender().Send(models.NewErrorEvent(
 			fmt.Errorf("only %d player(s) have joined, a minimum of %d are required before the game can be started", len(stage.players), stage.MinPlayers),
-		)
+		))
 		return nil
 	}
 
-	for _, p := range stage.players {
-		p.ServerEvents <- NewDidStartEvent(stage.players)
+	return startGame(stage)
+}
+
+func startGame(stage *JoinGame) models.StageRunner {
+	roster := buildRoster(stage)
+	stage.roster = roster
+	models.Broadcast(stage.players, NewDidStartEvent(roster))
+	return stage.StartGame(roster)
+}
+
+// Check that the event was sent by the host, the sender is told otherwise
+func requireHost(event models.PlayerEvent, stage *JoinGame, action string) bool {
+	if event.Sender() != stage.host {
+		event.Sender().Send(models.NewErrorEvent(fmt.Errorf("only the host can %s", action)))
+		return false
+	}
+	return true
+}
+
+func handleKick(event *KickEvent, stage *JoinGame) {
+	if !requireHost(event, stage, "kick players") {
+		return
+	}
+	player, err := models.FindPlayer(stage.players, event.PlayerID)
+	if err != nil {
+		event.Sender().Send(models.NewErrorEvent(err))
+		return
+	}
+	if player == stage.host {
+		event.Sender().Send(models.NewErrorEvent(fmt.Errorf("the host cannot be kicked, transfer the role to another player first")))
+		return
+	}
+
+	models.Broadcast(stage.players, NewDidKickEvent(player))
+	removePlayer(stage, player)
+	if player.Session != nil {
+		player.Session.RemovePlayer(player)
+	}
+}
+
+func handleLock(event *LockEvent, stage *JoinGame) {
+	if !requireHost(event, stage, "lock the lobby") {
+		return
+	}
+	stage.locked = event.Locked
+	models.Broadcast(stage.players, NewDidLockEvent(stage.locked))
+}
+
+func handleTransferHost(event *TransferHostEvent, stage *JoinGame) {
+	if !requireHost(event, stage, "transfer the role of host") {
+		return
+	}
+	player, err := models.FindPlayer(stage.players, event.PlayerID)
+	if err != nil {
+		event.Sender().Send(models.NewErrorEvent(err))
+		return
+	}
+	stage.host = player
+	models.Broadcast(stage.players, NewHostChangedEvent(player))
+}
+
+// Remove a player from the lobby, the role of host passes on to the next player who joined when
+// the host is removed
+func removePlayer(stage *JoinGame, player *models.Player) {
+	for i, p := range stage.players {
+		if p == player {
+			stage.players = append(stage.players[:i], stage.players[i+1:]...)
+			delete(stage.ready, player)
+			delete(stage.teamChoices, player)
+			break
+		}
+	}
+	if player != stage.host {
+		return
+	}
+
+	stage.host = nil
+	if len(stage.players) > 0 {
+		stage.host = stage.players[0]
+		models.Broadcast(stage.players, NewHostChangedEvent(stage.host))
 	}
-	return stage.StartGame(stage.players)
 }
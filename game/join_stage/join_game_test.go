@@ -2,21 +2,24 @@ package join_stage
 
 import (
 	"context"
+	"encoding/json"
 	"testing"
 	"time"
 
 	"github.com/sebmartin/collabd/models"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
 )
 
 func newJoinGameStage(min uint, max uint) JoinGame {
 	return JoinGame{
 		MinPlayers: min,
 		MaxPlayers: max,
-		StartGame: func(players []*models.Player) models.StageRunner {
+		StartGame: func(roster *Roster) models.StageRunner {
 			return &nextStage{
-				players: players,
+				players: roster.Players(),
+				roster:  roster,
 			}
 		},
 	}
@@ -24,6 +27,7 @@ func newJoinGameStage(min uint, max uint) JoinGame {
 
 type nextStage struct {
 	players []*models.Player
+	roster  *Roster
 }
 
 func (s *nextStage) Run(playerEvents <-chan models.PlayerEvent) models.StageRunner {
@@ -137,11 +141,18 @@ func TestStartGame_ServerEvents(t *testing.T) {
 	}
 	events <- NewStartEvent(context.Background(), players[0])
 
-	for _, p := range players {
+	// Each player is told about its own join and those that follow it, then that the game started
+	expected := [][]models.EventType{
+		{DidJoinEventType, DidJoinEventType, DidStartEventType},
+		{DidJoinEventType, DidStartEventType},
+	}
+	for i, p := range players {
 		serverEvents := flushServerEvents(p.ServerEvents)
-		require.Len(t, serverEvents, 2)
-		assert.Equal(t, DidJoinEventType, serverEvents[0].Type(), "player: %s", p.Name)
-		assert.Equal(t, DidStartEventType, serverEvents[1].Type(), "player: %s", p.Name)
+		types := make([]models.EventType, 0, len(serverEvents))
+		for _, event := range serverEvents {
+			types = append(types, event.Type())
+		}
+		assert.Equal(t, expected[i], types, "player: %s", p.Name)
 	}
 }
 
@@ -171,3 +182,220 @@ func TestStartGame_NextStage(t *testing.T) {
 	assert.IsType(t, &nextStage{}, theNextStage)
 	assert.Len(t, theNextStage.(*nextStage).players, len(players), "The next stage should have received a reference to all players")
 }
+
+func TestJoinGame_Restore(t *testing.T) {
+	alice := &models.Player{Model: &gorm.Model{ID: 1}, Name: "Alice"}
+	bob := &models.Player{Model: &gorm.Model{ID: 2}, Name: "Bob"}
+	stage := newJoinGameStage(2, 3)
+	stage.players = []*models.Player{bob, alice}
+	stage.host, stage.locked = alice, true
+	stage.ready = map[*models.Player]bool{bob: true}
+	encoded, _ := json.Marshal(stage.Snapshot())
+
+	restored := newJoinGameStage(2, 3)
+	err := restored.Restore(encoded, []*models.Player{alice, bob})
+	require.Nil(t, err)
+	assert.Equal(t, []*models.Player{bob, alice}, restored.players)
+	assert.Equal(t, alice, restored.host)
+	assert.True(t, restored.locked)
+	assert.Equal(t, map[*models.Player]bool{bob: true}, restored.ready)
+
+	err = restored.Restore(encoded, []*models.Player{alice})
+	assert.ErrorContains(t, err, "could not find player with id 2")
+}
+
+func TestJoinGame_RunContext_Ended(t *testing.T) {
+	stage := newJoinGameStage(1, 3)
+	events := newEventChannel()
+	close(events)
+	next, err := stage.RunContext(context.Background(), events)
+	assert.Nil(t, next)
+	assert.Nil(t, err, "A closed event channel should end the stage without an error")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	next, err = stage.RunContext(ctx, newEventChannel())
+	assert.Nil(t, next)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func newPlayerWithID(id uint, name string) *models.Player {
+	player := newPlayer(name)
+	player.Model = &gorm.Model{ID: id}
+	return player
+}
+
+// Join the players to a stage running in a go routine, the first player is the host
+func joinPlayers(t *testing.T, stage *JoinGame, players ...*models.Player) chan models.PlayerEvent {
+	events := newEventChannel()
+	go stage.Run(events)
+	for _, p := range players {
+		events <- NewJoinEvent(context.Background(), p)
+	}
+	// Each player is told about its own join and those of the players who joined afterwards
+	for i, p := range players {
+		serverEvents := flushServerEvents(p.ServerEvents)
+		require.Len(t, serverEvents, len(players)-i)
+		for j, event := range serverEvents {
+			require.IsType(t, &DidJoinEvent{}, event)
+			assert.Equal(t, players[i+j], event.(*DidJoinEvent).Player)
+			assert.Equal(t, players[0], event.(*DidJoinEvent).Host)
+		}
+	}
+	return events
+}
+
+func TestStartGame_HostOnly(t *testing.T) {
+	stage := newJoinGameStage(1, 3)
+	annie, steve := newPlayerWithID(1, "Annie"), newPlayerWithID(2, "Steve")
+	events := joinPlayers(t, &stage, annie, steve)
+
+	events <- NewStartEvent(context.Background(), steve)
+	serverEvents := flushServerEvents(steve.ServerEvents)
+	require.Len(t, serverEvents, 1)
+	assert.ErrorContains(t, serverEvents[0].(*models.ErrorEvent).Error, "only the host can start the game")
+}
+
+func TestJoinGame_Kick(t *testing.T) {
+	stage := newJoinGameStage(1, 3)
+	annie, steve := newPlayerWithID(1, "Annie"), newPlayerWithID(2, "Steve")
+	events := joinPlayers(t, &stage, annie, steve)
+
+	events <- NewKickEvent(context.Background(), steve, annie.ID)
+	serverEvents := flushServerEvents(steve.ServerEvents)
+	require.Len(t, serverEvents, 1)
+	assert.ErrorContains(t, serverEvents[0].(*models.ErrorEvent).Error, "only the host can kick players")
+
+	events <- NewKickEvent(context.Background(), annie, annie.ID)
+	serverEvents = flushServerEvents(annie.ServerEvents)
+	require.Len(t, serverEvents, 1)
+	assert.ErrorContains(t, serverEvents[0].(*models.ErrorEvent).Error, "the host cannot be kicked")
+
+	events <- NewKickEvent(context.Background(), annie, steve.ID)
+	for _, p := range []*models.Player{annie, steve} {
+		serverEvents = flushServerEvents(p.ServerEvents)
+		require.Len(t, serverEvents, 1, "player: %s", p.Name)
+		require.IsType(t, &DidKickEvent{}, serverEvents[0])
+		assert.Equal(t, steve, serverEvents[0].(*DidKickEvent).Player)
+	}
+}
+
+func TestJoinGame_Lock(t *testing.T) {
+	stage := newJoinGameStage(1, 3)
+	annie := newPlayerWithID(1, "Annie")
+	events := joinPlayers(t, &stage, annie)
+
+	events <- NewLockEvent(context.Background(), annie, true)
+	serverEvents := flushServerEvents(annie.ServerEvents)
+	require.Len(t, serverEvents, 1)
+	require.IsType(t, &DidLockEvent{}, serverEvents[0])
+	assert.True(t, serverEvents[0].(*DidLockEvent).Locked)
+
+	steve := newPlayerWithID(2, "Steve")
+	events <- NewJoinEvent(context.Background(), steve)
+	serverEvents = flushServerEvents(steve.ServerEvents)
+	require.Len(t, serverEvents, 1)
+	assert.ErrorContains(t, serverEvents[0].(*models.ErrorEvent).Error, "the lobby is locked")
+}
+
+func TestJoinGame_TransferHost(t *testing.T) {
+	stage := newJoinGameStage(1, 3)
+	annie, steve := newPlayerWithID(1, "Annie"), newPlayerWithID(2, "Steve")
+	events := joinPlayers(t, &stage, annie, steve)
+
+	events <- NewTransferHostEvent(context.Background(), annie, steve.ID)
+	for _, p := range []*models.Player{annie, steve} {
+		serverEvents := flushServerEvents(p.ServerEvents)
+		require.Len(t, serverEvents, 1, "player: %s", p.Name)
+		require.IsType(t, &HostChangedEvent{}, serverEvents[0])
+		assert.Equal(t, steve, serverEvents[0].(*HostChangedEvent).Host)
+	}
+
+	events <- NewLockEvent(context.Background(), annie, true)
+	serverEvents := flushServerEvents(annie.ServerEvents)
+	require.Len(t, serverEvents, 1)
+	assert.ErrorContains(t, serverEvents[0].(*models.ErrorEvent).Error, "only the host can lock the lobby")
+}
+
+func TestJoinGame_HostRemoved(t *testing.T) {
+	annie, steve := newPlayerWithID(1, "Annie"), newPlayerWithID(2, "Steve")
+	stage := newJoinGameStage(1, 3)
+	stage.players = []*models.Player{annie, steve}
+	stage.host = annie
+
+	removePlayer(&stage, annie)
+	assert.Equal(t, steve, stage.host, "The role of host should pass on to the next player")
+	serverEvents := flushServerEvents(steve.ServerEvents)
+	require.Len(t, serverEvents, 1)
+	assert.IsType(t, &HostChangedEvent{}, serverEvents[0])
+
+	removePlayer(&stage, steve)
+	assert.Nil(t, stage.host)
+}
+
+func TestJoinGame_BroadcastsJoins(t *testing.T) {
+	stage := newJoinGameStage(1, 3)
+	events := newEventChannel()
+	go stage.Run(events)
+	annie, steve, joan := newPlayerWithID(1, "Annie"), newPlayerWithID(2, "Steve"), newPlayerWithID(3, "Joan")
+	for _, p := range []*models.Player{annie, steve, joan} {
+		events <- NewJoinEvent(context.Background(), p)
+	}
+
+	// Every player receives the joins that follow its own, Annie is the host since they joined first
+	expected := map[*models.Player][]*models.Player{
+		annie: {annie, steve, joan},
+		steve: {steve, joan},
+		joan:  {joan},
+	}
+	for player, joined := range expected {
+		serverEvents := flushServerEvents(player.ServerEvents)
+		require.Len(t, serverEvents, len(joined), "player: %s", player.Name)
+		for i, event := range serverEvents {
+			require.IsType(t, &DidJoinEvent{}, event)
+			assert.Same(t, joined[i], event.(*DidJoinEvent).Player, "player: %s", player.Name)
+			assert.Same(t, annie, event.(*DidJoinEvent).Host, "player: %s", player.Name)
+		}
+	}
+}
+
+func TestJoinGame_Duplicate(t *testing.T) {
+	stage := newJoinGameStage(1, 3)
+	annie := newPlayerWithID(1, "Annie")
+	events := joinPlayers(t, &stage, annie)
+
+	events <- NewJoinEvent(context.Background(), annie)
+	serverEvents := flushServerEvents(annie.ServerEvents)
+	require.Len(t, serverEvents, 1)
+	assert.ErrorContains(t, serverEvents[0].(*models.ErrorEvent).Error, "player Annie has already joined")
+
+	other := newPlayerWithID(2, "annie")
+	events <- NewJoinEvent(context.Background(), other)
+	serverEvents = flushServerEvents(other.ServerEvents)
+	require.Len(t, serverEvents, 1)
+	assert.ErrorContains(t, serverEvents[0].(*models.ErrorEvent).Error, `the name "annie" is already taken`)
+}
+
+func TestJoinGame_Leave(t *testing.T) {
+	stage := newJoinGameStage(1, 3)
+	annie, steve := newPlayerWithID(1, "Annie"), newPlayerWithID(2, "Steve")
+	events := joinPlayers(t, &stage, annie, steve)
+
+	events <- NewLeaveEvent(context.Background(), annie)
+	serverEvents := flushServerEvents(annie.ServerEvents)
+	require.Len(t, serverEvents, 1)
+	require.IsType(t, &DidLeaveEvent{}, serverEvents[0])
+	assert.Equal(t, annie, serverEvents[0].(*DidLeaveEvent).Player)
+
+	// The host left, the role passes on to the other player
+	serverEvents = flushServerEvents(steve.ServerEvents)
+	require.Len(t, serverEvents, 2)
+	assert.IsType(t, &DidLeaveEvent{}, serverEvents[0])
+	require.IsType(t, &HostChangedEvent{}, serverEvents[1])
+	assert.Equal(t, steve, serverEvents[1].(*HostChangedEvent).Host)
+
+	events <- NewLeaveEvent(context.Background(), annie)
+	serverEvents = flushServerEvents(annie.ServerEvents)
+	require.Len(t, serverEvents, 1)
+	assert.ErrorContains(t, serverEvents[0].(*models.ErrorEvent).Error, "player Annie has not joined the lobby")
+}
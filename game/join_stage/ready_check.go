@@ -0,0 +1,74 @@
+package join_stage
+
+import (
+	"github.com/sebmartin/collabd/models"
+)
+
+// Name of the timer of the countdown to start the game
+const countdownTimer = "start"
+
+func (g *JoinGame) UseTimers(timers models.Timers) {
+	g.timers = timers
+}
+
+func handleReady(event *ReadyEvent, stage *JoinGame) {
+	player := event.Sender()
+	if !isMember(stage, player) {
+		player.Send(models.NewErrorEvent(errNotJoined(player)))
+		return
+	}
+
+	if stage.ready == nil {
+		stage.ready = make(map[*models.Player]bool)
+	}
+	if event.Ready {
+		stage.ready[player] = true
+	} else {
+		delete(stage.ready, player)
+	}
+	models.Broadcast(stage.players, NewDidReadyEvent(player, event.Ready))
+}
+
+// Start the game if the lobby meets the conditions of its start mode, or start the countdown to
+// start it. A countdown that is running is cancelled if the conditions are no longer met.
+func checkAutoStart(stage *JoinGame) models.StageRunner {
+	if stage.AutoStartWhenFull && len(stage.players) >= int(stage.MaxPlayers) {
+		cancelCountdown(stage)
+		return startGame(stage)
+	}
+
+	if !stage.ReadyCheck || !everyoneReady(stage) {
+		cancelCountdown(stage)
+		return nil
+	}
+	if stage.StartCountdown <= 0 || stage.timers == nil {
+		return startGame(stage)
+	}
+	if !stage.countingDown {
+		stage.countingDown = true
+		stage.timers.Schedule(countdownTimer, stage.StartCountdown)
+		models.Broadcast(stage.players, NewStartCountdownEvent(stage.StartCountdown))
+	}
+	return nil
+}
+
+func everyoneReady(stage *JoinGame) bool {
+	if len(stage.players) == 0 || len(stage.players) < int(stage.MinPlayers) {
+		return false
+	}
+	for _, p := range stage.players {
+		if !stage.ready[p] {
+			return false
+		}
+	}
+	return true
+}
+
+func cancelCountdown(stage *JoinGame) {
+	if !stage.countingDown {
+		return
+	}
+	stage.countingDown = false
+	stage.timers.Cancel(countdownTimer)
+	models.Broadcast(stage.players, NewStartCountdownCancelledEvent())
+}
@@ -0,0 +1,110 @@
+package join_stage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sebmartin/collabd/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Run the stage until it has handled the events, returns the next stage if the game started
+func runWithEvents(stage *JoinGame, events ...models.PlayerEvent) models.StageRunner {
+	channel := make(chan models.PlayerEvent, len(events))
+	for _, event := range events {
+		channel <- event
+	}
+	close(channel)
+	next, _ := stage.RunContext(context.Background(), channel)
+	return next
+}
+
+func eventTypes(events []models.ServerEvent) []models.EventType {
+	types := make([]models.EventType, 0, len(events))
+	for _, event := range events {
+		types = append(types, event.Type())
+	}
+	return types
+}
+
+func TestJoinGame_AutoStartWhenFull(t *testing.T) {
+	stage := newJoinGameStage(1, 2)
+	stage.AutoStartWhenFull = true
+	annie, steve := newPlayerWithID(1, "Annie"), newPlayerWithID(2, "Steve")
+
+	next := runWithEvents(&stage, NewJoinEvent(context.Background(), annie))
+	assert.Nil(t, next, "The game should not start before the lobby is full")
+
+	next = runWithEvents(&stage, NewJoinEvent(context.Background(), steve))
+	require.IsType(t, &nextStage{}, next)
+	assert.Equal(t, []*models.Player{annie, steve}, next.(*nextStage).players)
+}
+
+func TestJoinGame_ReadyCheck(t *testing.T) {
+	stage := newJoinGameStage(2, 3)
+	stage.ReadyCheck = true
+	annie, steve := newPlayerWithID(1, "Annie"), newPlayerWithID(2, "Steve")
+
+	next := runWithEvents(&stage,
+		NewJoinEvent(context.Background(), annie),
+		NewReadyEvent(context.Background(), annie, true),
+	)
+	assert.Nil(t, next, "The game should not start before the minimum number of players have joined")
+
+	next = runWithEvents(&stage,
+		NewJoinEvent(context.Background(), steve),
+		NewReadyEvent(context.Background(), steve, true),
+	)
+	require.IsType(t, &nextStage{}, next)
+
+	serverEvents := flushServerEvents(steve.ServerEvents)
+	assert.Equal(t, []models.EventType{DidJoinEventType, DidReadyEventType, DidStartEventType}, eventTypes(serverEvents))
+	require.IsType(t, &DidReadyEvent{}, serverEvents[1])
+	assert.Equal(t, steve, serverEvents[1].(*DidReadyEvent).Player)
+	assert.True(t, serverEvents[1].(*DidReadyEvent).Ready)
+}
+
+func TestJoinGame_ReadyCheck_NotJoined(t *testing.T) {
+	stage := newJoinGameStage(1, 3)
+	stage.ReadyCheck = true
+	annie := newPlayerWithID(1, "Annie")
+
+	next := runWithEvents(&stage, NewReadyEvent(context.Background(), annie, true))
+	assert.Nil(t, next)
+	serverEvents := flushServerEvents(annie.ServerEvents)
+	require.Len(t, serverEvents, 1)
+	assert.ErrorContains(t, serverEvents[0].(*models.ErrorEvent).Error, "player Annie has not joined the lobby")
+}
+
+func TestJoinGame_ReadyCheck_Countdown(t *testing.T) {
+	stage := newJoinGameStage(2, 3)
+	stage.ReadyCheck, stage.StartCountdown = true, 5*time.Second
+	timers := models.NewFakeTimers()
+	stage.UseTimers(timers)
+	annie, steve := newPlayerWithID(1, "Annie"), newPlayerWithID(2, "Steve")
+
+	next := runWithEvents(&stage,
+		NewJoinEvent(context.Background(), annie),
+		NewJoinEvent(context.Background(), steve),
+		NewReadyEvent(context.Background(), annie, true),
+		NewReadyEvent(context.Background(), steve, true),
+	)
+	assert.Nil(t, next, "The game should only start at the end of the countdown")
+	assert.Equal(t, map[string]time.Duration{countdownTimer: 5 * time.Second}, timers.Scheduled())
+	serverEvents := flushServerEvents(annie.ServerEvents)
+	require.IsType(t, &StartCountdownEvent{}, serverEvents[len(serverEvents)-1])
+	assert.Equal(t, 5, serverEvents[len(serverEvents)-1].(*StartCountdownEvent).Seconds())
+
+	// Steve is no longer ready
+	next = runWithEvents(&stage, NewReadyEvent(context.Background(), steve, false))
+	assert.Nil(t, next)
+	assert.Empty(t, timers.Scheduled())
+	assert.Equal(t, []models.EventType{DidReadyEventType, StartCountdownCancelledEventType}, eventTypes(flushServerEvents(annie.ServerEvents)))
+
+	next = runWithEvents(&stage, NewReadyEvent(context.Background(), steve, true))
+	assert.Nil(t, next)
+	next = runWithEvents(&stage, timers.Fire(countdownTimer))
+	assert.IsType(t, &nextStage{}, next)
+}
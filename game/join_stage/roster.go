@@ -0,0 +1,237 @@
+package join_stage
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/sebmartin/collabd/models"
+)
+
+// How the seats, and therefore the turn order, are assigned when the game starts
+type SeatingPolicy string
+
+const (
+	// Players are seated in the order they joined
+	SeatingJoinOrder SeatingPolicy = ""
+	// Players are seated in a random order
+	SeatingRandom SeatingPolicy = "random"
+	// The host picks the order with a SeatOrderEvent, the players it did not list are seated
+	// after the others in the order they joined
+	SeatingHostChosen SeatingPolicy = "host"
+)
+
+// How players are split into teams when the game has teams
+type TeamAssignment string
+
+const (
+	// Players pick their team with a ChooseTeamEvent, those who didn't are put in the smallest team
+	TeamsManual TeamAssignment = ""
+	// Players are dealt to the teams at random
+	TeamsRandom TeamAssignment = "random"
+	// Players are dealt to the teams in seat order so that the teams alternate around the table
+	TeamsBalanced TeamAssignment = "balanced"
+)
+
+// A player's place in the game
+type Seat struct {
+	Player *models.Player
+	// Position in the turn order, starting at 0
+	Index int
+	// Empty when the game has no teams
+	Team string
+}
+
+// Players of a game in turn order, handed to the game's first stage when it starts
+type Roster struct {
+	Seats []Seat
+}
+
+// The players in turn order
+func (r *Roster) Players() []*models.Player {
+	players := make([]*models.Player, 0, len(r.Seats))
+	for _, seat := range r.Seats {
+		players = append(players, seat.Player)
+	}
+	return players
+}
+
+// The players of a team in turn order
+func (r *Roster) Team(team string) []*models.Player {
+	var players []*models.Player
+	for _, seat := range r.Seats {
+		if seat.Team == team {
+			players = append(players, seat.Player)
+		}
+	}
+	return players
+}
+
+// The players of the player's team in turn order, including the player, see models.TeamStage
+func (r *Roster) Teammates(player *models.Player) []*models.Player {
+	for _, seat := range r.Seats {
+		if seat.Player == player && seat.Team != "" {
+			return r.Team(seat.Team)
+		}
+	}
+	return nil
+}
+
+// Seat the players of the lobby according to the stage's policies
+func buildRoster(stage *JoinGame) *Roster {
+	players := seatPlayers(stage)
+	roster := &Roster{Seats: make([]Seat, 0, len(players))}
+	for i, p := range players {
+		roster.Seats = append(roster.Seats, Seat{Player: p, Index: i})
+	}
+	assignTeams(stage, roster)
+	return roster
+}
+
+func seatPlayers(stage *JoinGame) []*models.Player {
+	players := make([]*models.Player, len(stage.players))
+	copy(players, stage.players)
+
+	switch stage.Seating {
+	case SeatingRandom:
+		stage.shuffle(len(players), func(i, j int) {
+			players[i], players[j] = players[j], players[i]
+		})
+	case SeatingHostChosen:
+		seated := make([]*models.Player, 0, len(players))
+		for _, p := range stage.seatOrder {
+			if isMember(stage, p) {
+				seated = append(seated, p)
+			}
+		}
+		for _, p := range players {
+			if !containsPlayer(seated, p) {
+				seated = append(seated, p)
+			}
+		}
+		players = seated
+	}
+	return players
+}
+
+func assignTeams(stage *JoinGame, roster *Roster) {
+	if len(stage.Teams) == 0 {
+		return
+	}
+
+	switch stage.TeamAssignment {
+	case TeamsRandom:
+		order := make([]int, len(roster.Seats))
+		for i := range order {
+			order[i] = i
+		}
+		stage.shuffle(len(order), func(i, j int) {
+			order[i], order[j] = order[j], order[i]
+		})
+		for n, i := range order {
+			roster.Seats[i].Team = stage.Teams[n%len(stage.Teams)]
+		}
+	case TeamsBalanced:
+		for i := range roster.Seats {
+			roster.Seats[i].Team = stage.Teams[i%len(stage.Teams)]
+		}
+	default:
+		sizes := make(map[string]int)
+		for i, seat := range roster.Seats {
+			if team, ok := stage.teamChoices[seat.Player]; ok {
+				roster.Seats[i].Team = team
+				sizes[team]++
+			}
+		}
+		for i, seat := range roster.Seats {
+			if seat.Team == "" {
+				team := smallestTeam(stage.Teams, sizes)
+				roster.Seats[i].Team = team
+				sizes[team]++
+			}
+		}
+	}
+}
+
+func smallestTeam(teams []string, sizes map[string]int) string {
+	smallest := teams[0]
+	for _, team := range teams[1:] {
+		if sizes[team] < sizes[smallest] {
+			smallest = team
+		}
+	}
+	return smallest
+}
+
+func containsPlayer(players []*models.Player, player *models.Player) bool {
+	for _, p := range players {
+		if p == player {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *JoinGame) shuffle(n int, swap func(i, j int)) {
+	if g.Shuffle != nil {
+		g.Shuffle(n, swap)
+		return
+	}
+	rand.Shuffle(n, swap)
+}
+
+func handleChooseTeam(event *ChooseTeamEvent, stage *JoinGame) {
+	player := event.Sender()
+	if !isMember(stage, player) {
+		player.Send(models.NewErrorEvent(errNotJoined(player)))
+		return
+	}
+	if len(stage.Teams) == 0 || stage.TeamAssignment != TeamsManual {
+		player.Send(models.NewErrorEvent(fmt.Errorf("players cannot choose their team in this game")))
+		return
+	}
+	if !containsTeam(stage.Teams, event.Team) {
+		player.Send(models.NewErrorEvent(fmt.Errorf("unknown team: %s", event.Team)))
+		return
+	}
+
+	if stage.teamChoices == nil {
+		stage.teamChoices = make(map[*models.Player]string)
+	}
+	stage.teamChoices[player] = event.Team
+	models.Broadcast(stage.players, NewDidChooseTeamEvent(player, event.Team))
+}
+
+func containsTeam(teams []string, team string) bool {
+	for _, t := range teams {
+		if t == team {
+			return true
+		}
+	}
+	return false
+}
+
+func handleSeatOrder(event *SeatOrderEvent, stage *JoinGame) {
+	if !requireHost(event, stage, "choose the seats") {
+		return
+	}
+	if stage.Seating != SeatingHostChosen {
+		event.Sender().Send(models.NewErrorEvent(fmt.Errorf("the seats are not chosen by the host in this game")))
+		return
+	}
+
+	order := make([]*models.Player, 0, len(event.PlayerIDs))
+	for _, id := range event.PlayerIDs {
+		player, err := models.FindPlayer(stage.players, id)
+		if err != nil {
+			event.Sender().Send(models.NewErrorEvent(err))
+			return
+		}
+		if containsPlayer(order, player) {
+			event.Sender().Send(models.NewErrorEvent(fmt.Errorf("player %s is seated more than once", player.Name)))
+			return
+		}
+		order = append(order, player)
+	}
+	stage.seatOrder = order
+	models.Broadcast(stage.players, NewDidSeatOrderEvent(order))
+}
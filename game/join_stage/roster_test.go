@@ -0,0 +1,242 @@
+package join_stage
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/sebmartin/collabd/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Deterministic shuffle that reverses the order
+func reverse(n int, swap func(i, j int)) {
+	for i := 0; i < n/2; i++ {
+		swap(i, n-1-i)
+	}
+}
+
+func startedRoster(t *testing.T, next models.StageRunner) *Roster {
+	require.IsType(t, &nextStage{}, next)
+	return next.(*nextStage).roster
+}
+
+func seatTeams(roster *Roster) []string {
+	teams := make([]string, 0, len(roster.Seats))
+	for _, seat := range roster.Seats {
+		teams = append(teams, seat.Team)
+	}
+	return teams
+}
+
+func TestJoinGame_Seating_JoinOrder(t *testing.T) {
+	stage := newJoinGameStage(1, 3)
+	annie, steve := newPlayerWithID(1, "Annie"), newPlayerWithID(2, "Steve")
+
+	next := runWithEvents(&stage,
+		NewJoinEvent(context.Background(), annie),
+		NewJoinEvent(context.Background(), steve),
+		NewStartEvent(context.Background(), annie),
+	)
+	roster := startedRoster(t, next)
+	assert.Equal(t, []Seat{{Player: annie, Index: 0}, {Player: steve, Index: 1}}, roster.Seats)
+
+	serverEvents := flushServerEvents(steve.ServerEvents)
+	require.IsType(t, &DidStartEvent{}, serverEvents[len(serverEvents)-1])
+	assert.Equal(t, roster.Seats, serverEvents[len(serverEvents)-1].(*DidStartEvent).Seats)
+}
+
+func TestJoinGame_Seating_Random(t *testing.T) {
+	stage := newJoinGameStage(1, 3)
+	stage.Seating, stage.Shuffle = SeatingRandom, reverse
+	annie, steve, mikey := newPlayerWithID(1, "Annie"), newPlayerWithID(2, "Steve"), newPlayerWithID(3, "Mikey")
+
+	next := runWithEvents(&stage,
+		NewJoinEvent(context.Background(), annie),
+		NewJoinEvent(context.Background(), steve),
+		NewJoinEvent(context.Background(), mikey),
+		NewStartEvent(context.Background(), annie),
+	)
+	assert.Equal(t, []*models.Player{mikey, steve, annie}, startedRoster(t, next).Players())
+}
+
+func TestJoinGame_Seating_HostChosen(t *testing.T) {
+	stage := newJoinGameStage(1, 3)
+	stage.Seating = SeatingHostChosen
+	annie, steve, mikey := newPlayerWithID(1, "Annie"), newPlayerWithID(2, "Steve"), newPlayerWithID(3, "Mikey")
+
+	next := runWithEvents(&stage,
+		NewJoinEvent(context.Background(), annie),
+		NewJoinEvent(context.Background(), steve),
+		NewJoinEvent(context.Background(), mikey),
+		NewSeatOrderEvent(context.Background(), annie, []uint{3, 2}),
+	)
+	assert.Nil(t, next)
+	serverEvents := flushServerEvents(steve.ServerEvents)
+	require.IsType(t, &DidSeatOrderEvent{}, serverEvents[len(serverEvents)-1])
+	assert.Equal(t, []*models.Player{mikey, steve}, serverEvents[len(serverEvents)-1].(*DidSeatOrderEvent).Players)
+
+	// Annie was not listed, they are seated after the others
+	next = runWithEvents(&stage, NewStartEvent(context.Background(), annie))
+	assert.Equal(t, []*models.Player{mikey, steve, annie}, startedRoster(t, next).Players())
+}
+
+func TestJoinGame_Seating_HostChosen_Errors(t *testing.T) {
+	stage := newJoinGameStage(1, 3)
+	stage.Seating = SeatingHostChosen
+	annie, steve := newPlayerWithID(1, "Annie"), newPlayerWithID(2, "Steve")
+	runWithEvents(&stage,
+		NewJoinEvent(context.Background(), annie),
+		NewJoinEvent(context.Background(), steve),
+	)
+	flushServerEvents(annie.ServerEvents)
+	flushServerEvents(steve.ServerEvents)
+
+	runWithEvents(&stage, NewSeatOrderEvent(context.Background(), steve, []uint{2, 1}))
+	serverEvents := flushServerEvents(steve.ServerEvents)
+	require.Len(t, serverEvents, 1)
+	assert.ErrorContains(t, serverEvents[0].(*models.ErrorEvent).Error, "only the host can choose the seats")
+
+	runWithEvents(&stage, NewSeatOrderEvent(context.Background(), annie, []uint{2, 2}))
+	serverEvents = flushServerEvents(annie.ServerEvents)
+	require.Len(t, serverEvents, 1)
+	assert.ErrorContains(t, serverEvents[0].(*models.ErrorEvent).Error, "player Steve is seated more than once")
+
+	stage.Seating = SeatingRandom
+	runWithEvents(&stage, NewSeatOrderEvent(context.Background(), annie, []uint{2, 1}))
+	serverEvents = flushServerEvents(annie.ServerEvents)
+	require.Len(t, serverEvents, 1)
+	assert.ErrorContains(t, serverEvents[0].(*models.ErrorEvent).Error, "the seats are not chosen by the host")
+}
+
+func TestJoinGame_Teams_Manual(t *testing.T) {
+	stage := newJoinGameStage(1, 4)
+	stage.Teams = []string{"red", "blue"}
+	annie, steve, mikey := newPlayerWithID(1, "Annie"), newPlayerWithID(2, "Steve"), newPlayerWithID(3, "Mikey")
+
+	next := runWithEvents(&stage,
+		NewJoinEvent(context.Background(), annie),
+		NewJoinEvent(context.Background(), steve),
+		NewJoinEvent(context.Background(), mikey),
+		NewChooseTeamEvent(context.Background(), annie, "blue"),
+		NewChooseTeamEvent(context.Background(), steve, "blue"),
+		NewChooseTeamEvent(context.Background(), annie, "green"),
+		NewStartEvent(context.Background(), annie),
+	)
+	roster := startedRoster(t, next)
+	// Mikey did not choose, they are put in the smallest team
+	assert.Equal(t, []string{"blue", "blue", "red"}, seatTeams(roster))
+	assert.Equal(t, []*models.Player{annie, steve}, roster.Team("blue"))
+
+	serverEvents := flushServerEvents(annie.ServerEvents)
+	var errors []string
+	for _, event := range serverEvents {
+		if event, ok := event.(*models.ErrorEvent); ok {
+			errors = append(errors, event.Message())
+		}
+	}
+	assert.Equal(t, []string{"unknown team: green"}, errors)
+}
+
+func TestJoinGame_Teams_Balanced(t *testing.T) {
+	stage := newJoinGameStage(1, 4)
+	stage.Teams, stage.TeamAssignment = []string{"red", "blue"}, TeamsBalanced
+	players := []*models.Player{
+		newPlayerWithID(1, "Annie"), newPlayerWithID(2, "Steve"), newPlayerWithID(3, "Mikey"), newPlayerWithID(4, "Lucy"),
+	}
+
+	events := make([]models.PlayerEvent, 0, len(players)+2)
+	for _, p := range players {
+		events = append(events, NewJoinEvent(context.Background(), p))
+	}
+	events = append(events,
+		NewChooseTeamEvent(context.Background(), players[1], "red"),
+		NewStartEvent(context.Background(), players[0]),
+	)
+	roster := startedRoster(t, runWithEvents(&stage, events...))
+	assert.Equal(t, []string{"red", "blue", "red", "blue"}, seatTeams(roster))
+
+	serverEvents := flushServerEvents(players[1].ServerEvents)
+	var errorEvent *models.ErrorEvent
+	for _, event := range serverEvents {
+		if event, ok := event.(*models.ErrorEvent); ok {
+			errorEvent = event
+		}
+	}
+	require.NotNil(t, errorEvent, "Players cannot choose their team when teams are balanced")
+	assert.Equal(t, "players cannot choose their team in this game", errorEvent.Message())
+}
+
+func TestJoinGame_Teams_Random(t *testing.T) {
+	stage := newJoinGameStage(1, 4)
+	stage.Teams, stage.TeamAssignment, stage.Shuffle = []string{"red", "blue"}, TeamsRandom, reverse
+	annie, steve, mikey := newPlayerWithID(1, "Annie"), newPlayerWithID(2, "Steve"), newPlayerWithID(3, "Mikey")
+
+	next := runWithEvents(&stage,
+		NewJoinEvent(context.Background(), annie),
+		NewJoinEvent(context.Background(), steve),
+		NewJoinEvent(context.Background(), mikey),
+		NewStartEvent(context.Background(), annie),
+	)
+	roster := startedRoster(t, next)
+	assert.Equal(t, []*models.Player{annie, steve, mikey}, roster.Players(), "Teams should not change the seats")
+	assert.Equal(t, []string{"red", "blue", "red"}, seatTeams(roster))
+}
+
+func TestJoinGame_Restore_Roster(t *testing.T) {
+	stage := newJoinGameStage(1, 3)
+	stage.Seating, stage.Teams = SeatingHostChosen, []string{"red", "blue"}
+	annie, steve := newPlayerWithID(1, "Annie"), newPlayerWithID(2, "Steve")
+	runWithEvents(&stage,
+		NewJoinEvent(context.Background(), annie),
+		NewJoinEvent(context.Background(), steve),
+		NewChooseTeamEvent(context.Background(), annie, "blue"),
+		NewSeatOrderEvent(context.Background(), annie, []uint{2, 1}),
+	)
+	state, err := json.Marshal(stage.Snapshot())
+	require.Nil(t, err)
+
+	restored := newJoinGameStage(1, 3)
+	restored.Seating, restored.Teams = SeatingHostChosen, []string{"red", "blue"}
+	require.Nil(t, restored.Restore(state, []*models.Player{annie, steve}))
+
+	roster := startedRoster(t, runWithEvents(&restored, NewStartEvent(context.Background(), annie)))
+	assert.Equal(t, []Seat{
+		{Player: steve, Index: 0, Team: "red"},
+		{Player: annie, Index: 1, Team: "blue"},
+	}, roster.Seats)
+}
+
+func TestJoinGame_Teammates(t *testing.T) {
+	stage := newJoinGameStage(1, 3)
+	stage.Teams = []string{"red", "blue"}
+	annie, steve, mikey := newPlayerWithID(1, "Annie"), newPlayerWithID(2, "Steve"), newPlayerWithID(3, "Mikey")
+	runWithEvents(&stage,
+		NewJoinEvent(context.Background(), annie),
+		NewJoinEvent(context.Background(), steve),
+		NewJoinEvent(context.Background(), mikey),
+		NewChooseTeamEvent(context.Background(), annie, "blue"),
+		NewChooseTeamEvent(context.Background(), mikey, "blue"),
+	)
+
+	assert.Equal(t, []*models.Player{annie, mikey}, stage.Teammates(mikey))
+	assert.Empty(t, stage.Teammates(steve), "Players who did not choose a team have no teammates yet")
+}
+
+func TestJoinGame_Teammates_Started(t *testing.T) {
+	stage := newJoinGameStage(1, 3)
+	stage.Teams, stage.TeamAssignment = []string{"red", "blue"}, TeamsBalanced
+	annie, steve, mikey := newPlayerWithID(1, "Annie"), newPlayerWithID(2, "Steve"), newPlayerWithID(3, "Mikey")
+	runWithEvents(&stage,
+		NewJoinEvent(context.Background(), annie),
+		NewJoinEvent(context.Background(), steve),
+		NewJoinEvent(context.Background(), mikey),
+	)
+	assert.Empty(t, stage.Teammates(annie), "Balanced teams are only known once the game starts")
+
+	roster := startedRoster(t, runWithEvents(&stage, NewStartEvent(context.Background(), annie)))
+	assert.Equal(t, []*models.Player{annie, mikey}, stage.Teammates(annie))
+	assert.Equal(t, []*models.Player{steve}, stage.Teammates(steve))
+	assert.Equal(t, roster.Teammates(mikey), stage.Teammates(mikey))
+}
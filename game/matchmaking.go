@@ -0,0 +1,449 @@
+package game
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sebmartin/collabd/game/join_stage"
+	"github.com/sebmartin/collabd/models"
+)
+
+// Returned by a ticket that left its queue before it was matched
+var ErrLeftQueue = errors.New("left the matchmaking queue")
+
+// Default amount of time a match waits for more players once it has enough of them
+const DefaultGatherWindow = 10 * time.Second
+
+// Constraints of a player waiting in a matchmaking queue
+type QueueOptions struct {
+	// Skill rating of the player, only compared to the ratings of other players
+	Skill int
+	// Largest difference of skill the player accepts with the other players of a match, any
+	// difference is accepted when zero
+	MaxSkillGap int
+}
+
+// A player waiting in a matchmaking queue
+type Ticket struct {
+	// Secret identifier of the ticket, only its owner should know it to be able to leave the queue
+	ID       string
+	GameKey  string
+	Name     string
+	Skill    int
+	QueuedAt time.Time
+	// See QueueOptions
+	MaxSkillGap int
+
+	done        chan struct{}
+	credentials *PlayerCredentials
+	err         error
+}
+
+// Closed once the ticket was matched, the match failed or the ticket left its queue
+func (t *Ticket) Done() <-chan struct{} {
+	return t.done
+}
+
+// Credentials of the player that joined a session for the ticket, only set once the ticket is done
+func (t *Ticket) Result() (*PlayerCredentials, error) {
+	return t.credentials, t.err
+}
+
+func (t *Ticket) finish(credentials *PlayerCredentials, err error) {
+	t.credentials, t.err = credentials, err
+	close(t.done)
+}
+
+// Whether two players can be matched together
+func (t *Ticket) compatible(other *Ticket) bool {
+	if strings.EqualFold(t.Name, other.Name) {
+		// They could not join the same session
+		return false
+	}
+	gap := t.Skill - other.Skill
+	if gap < 0 {
+		gap = -gap
+	}
+	return (t.MaxSkillGap == 0 || gap <= t.MaxSkillGap) && (other.MaxSkillGap == 0 || gap <= other.MaxSkillGap)
+}
+
+// Sent to a player waiting in a matchmaking queue, see Matchmaker.Queue()
+type MatchmakingUpdate struct {
+	Ticket *Ticket
+	// Set once the player has joined the session of its match
+	Match *PlayerCredentials
+	// Set when the match could not be played
+	Err error
+}
+
+func (u *MatchmakingUpdate) Error() *string {
+	if u.Err == nil {
+		return nil
+	}
+	message := u.Err.Error()
+	return &message
+}
+
+// How busy a matchmaking queue is
+type QueueStats struct {
+	GameKey string
+	// Number of players waiting in the queue
+	Size        int
+	LongestWait time.Duration
+	AverageWait time.Duration
+}
+
+func (s QueueStats) LongestWaitSeconds() int {
+	return int(s.LongestWait.Seconds())
+}
+
+func (s QueueStats) AverageWaitSeconds() int {
+	return int(s.AverageWait.Seconds())
+}
+
+// Matches players who are waiting to play a game. Once enough compatible players are queued for
+// a game, the match waits for more of them during the gather window unless it is already full. A
+// private session is then started for them and they join it in the order they were queued, which
+// makes the player who waited the longest the host. The game is then started.
+type Matchmaker struct {
+	server *Server
+	// How long a match that has the game's minimum number of players waits for more players to
+	// fill it up, matches are played right away with the minimum number of players when zero
+	GatherWindow time.Duration
+
+	mu     sync.Mutex
+	queues map[string][]*Ticket
+	// Timers of the queues whose match is waiting for more players, see gather()
+	gathering map[string]models.Timer
+}
+
+func newMatchmaker(server *Server) *Matchmaker {
+	return &Matchmaker{
+		server:       server,
+		GatherWindow: DefaultGatherWindow,
+		queues:       make(map[string][]*Ticket),
+		gathering:    make(map[string]models.Timer),
+	}
+}
+
+// Wait in the queue of a game until a match is found. The first update holds the player's ticket,
+// the next one tells how the match went. The channel is closed after the match, or once the
+// context is done in which case the player leaves the queue.
+func (m *Matchmaker) Queue(ctx context.Context, gameKey string, name string, options QueueOptions) (<-chan *MatchmakingUpdate, error) {
+	ticket, err := m.Enqueue(gameKey, name, options)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := make(chan *MatchmakingUpdate, 2)
+	updates <- &MatchmakingUpdate{Ticket: ticket}
+	go func() {
+		defer close(updates)
+		select {
+		case <-ticket.Done():
+		case <-ctx.Done():
+			if err := m.Leave(ticket.ID); err != nil {
+				// The ticket was matched in the meantime, the player must not be waited for
+				m.abandon(ticket)
+			}
+			return
+		}
+
+		credentials, err := ticket.Result()
+		if errors.Is(err, ErrLeftQueue) {
+			return
+		}
+		updates <- &MatchmakingUpdate{Ticket: ticket, Match: credentials, Err: err}
+	}()
+	return updates, nil
+}
+
+// Add a player to the queue of a game, the ticket is done once the player was matched. The match
+// is played once the game's minimum number of compatible players have waited for the gather
+// window, or as soon as the game's maximum number of them are waiting.
+func (m *Matchmaker) Enqueue(gameKey string, name string, options QueueOptions) (*Ticket, error) {
+	if name == "" {
+		return nil, fmt.Errorf("a name is required to join a matchmaking queue")
+	}
+	if options.MaxSkillGap < 0 {
+		return nil, fmt.Errorf("the maximum skill gap must not be negative")
+	}
+	info, err := gameInfo(gameKey)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := ticketID()
+	if err != nil {
+		return nil, err
+	}
+	ticket := &Ticket{
+		ID:          id,
+		GameKey:     gameKey,
+		Name:        name,
+		Skill:       options.Skill,
+		MaxSkillGap: options.MaxSkillGap,
+		QueuedAt:    m.server.Clock.Now(),
+		done:        make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	// Checked while holding the lock so that the ticket is either refused or failed by Shutdown()
+	if m.server.isShuttingDown() {
+		m.mu.Unlock()
+		return nil, ErrShuttingDown
+	}
+	m.queues[gameKey] = append(m.queues[gameKey], ticket)
+	matches := m.takeMatches(gameKey, info, m.GatherWindow > 0)
+	m.mu.Unlock()
+
+	for _, match := range matches {
+		go m.play(gameKey, match)
+	}
+	return ticket, nil
+}
+
+func ticketID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// Remove every match that can be taken from the queue of a game, see takeMatch()
+func (m *Matchmaker) takeMatches(gameKey string, info GameInfo, gather bool) [][]*Ticket {
+	var matches [][]*Ticket
+	for match := m.takeMatch(gameKey, info, gather); match != nil; match = m.takeMatch(gameKey, info, gather) {
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+// Remove a match from the queue of a game if enough compatible players are waiting. The players
+// who waited the longest are matched first. When gathering, only a full match is taken and the
+// queue waits for more players if a smaller match could be played.
+func (m *Matchmaker) takeMatch(gameKey string, info GameInfo, gather bool) []*Ticket {
+	queue := m.queues[gameKey]
+	minPlayers := int(info.MinPlayers)
+	if minPlayers == 0 {
+		minPlayers = 1
+	}
+
+	waiting := false
+	for i, first := range queue {
+		match := []*Ticket{first}
+		for j, candidate := range queue {
+			if len(match) == int(info.MaxPlayers) {
+				break
+			}
+			if j != i && compatibleWithAll(candidate, match) {
+				match = append(match, candidate)
+			}
+		}
+		if len(match) < minPlayers {
+			continue
+		}
+		if gather && len(match) < int(info.MaxPlayers) {
+			waiting = true
+			continue
+		}
+
+		remaining := make([]*Ticket, 0, len(queue)-len(match))
+		for _, ticket := range queue {
+			if !containsTicket(match, ticket) {
+				remaining = append(remaining, ticket)
+			}
+		}
+		m.queues[gameKey] = remaining
+		// Join in the order they were queued
+		sortTickets(match, queue)
+		m.stopGathering(gameKey)
+		return match
+	}
+	if waiting {
+		m.gather(gameKey, info)
+	} else {
+		m.stopGathering(gameKey)
+	}
+	return nil
+}
+
+// Wait for the gather window before playing the matches of a queue with the players who are
+// waiting by then. Called while holding m.mu.
+func (m *Matchmaker) gather(gameKey string, info GameInfo) {
+	if _, ok := m.gathering[gameKey]; ok {
+		return
+	}
+
+	var timer models.Timer
+	timer = m.server.Clock.AfterFunc(m.GatherWindow, func() {
+		m.mu.Lock()
+		if m.gathering[gameKey] != timer {
+			// Stopped after it had already fired
+			m.mu.Unlock()
+			return
+		}
+		delete(m.gathering, gameKey)
+		matches := m.takeMatches(gameKey, info, false)
+		m.mu.Unlock()
+
+		for _, match := range matches {
+			go m.play(gameKey, match)
+		}
+	})
+	m.gathering[gameKey] = timer
+}
+
+// Called while holding m.mu
+func (m *Matchmaker) stopGathering(gameKey string) {
+	if timer, ok := m.gathering[gameKey]; ok {
+		timer.Stop()
+		delete(m.gathering, gameKey)
+	}
+}
+
+func compatibleWithAll(ticket *Ticket, match []*Ticket) bool {
+	for _, other := range match {
+		if !ticket.compatible(other) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsTicket(tickets []*Ticket, ticket *Ticket) bool {
+	for _, t := range tickets {
+		if t == ticket {
+			return true
+		}
+	}
+	return false
+}
+
+// Sort the tickets of a match in the order they appear in the queue
+func sortTickets(match []*Ticket, queue []*Ticket) {
+	sorted := make([]*Ticket, 0, len(match))
+	for _, ticket := range queue {
+		if containsTicket(match, ticket) {
+			sorted = append(sorted, ticket)
+		}
+	}
+	copy(match, sorted)
+}
+
+// Start a session for a match, join its players and start the game. The players are told how it
+// went through their tickets.
+func (m *Matchmaker) play(gameKey string, match []*Ticket) {
+	ctx := context.Background()
+	session, err := m.server.NewPrivateSession(ctx, gameKey, nil, "")
+	if err != nil {
+		failMatch(match, err)
+		return
+	}
+
+	joined := make([]*PlayerCredentials, 0, len(match))
+	for _, ticket := range match {
+		credentials, err := m.server.join(ctx, session, ticket.Name)
+		if err != nil {
+			session.Abort("A player could not join the match")
+			failMatch(match, fmt.Errorf("player %s could not join the match: %w", ticket.Name, err))
+			return
+		}
+		joined = append(joined, credentials)
+	}
+
+	// The game may have started on its own once everyone joined
+	state, err := session.State(ctx)
+	if err != nil {
+		session.Abort("The match could not be started")
+		failMatch(match, fmt.Errorf("the match could not be started: %w", err))
+		return
+	}
+	if state.Stage == join_stage.StageName {
+		session.HandlePlayerEvent(join_stage.NewStartEvent(ctx, joined[0].Player))
+	}
+	for i, ticket := range match {
+		ticket.finish(joined[i], nil)
+	}
+}
+
+func failMatch(match []*Ticket, err error) {
+	log.Printf("Matchmaking failed: %s", err)
+	for _, ticket := range match {
+		ticket.finish(nil, err)
+	}
+}
+
+// Give up on a ticket that could no longer leave its queue. Once it is done, the match it is part
+// of is aborted rather than played with a player who is not there.
+func (m *Matchmaker) abandon(ticket *Ticket) {
+	<-ticket.Done()
+	credentials, err := ticket.Result()
+	if err != nil {
+		return
+	}
+	log.Printf("Player %s left the queue after they were matched", ticket.Name)
+	credentials.Player.Session.Abort(fmt.Sprintf("Player %s left the match", ticket.Name))
+}
+
+// Remove a ticket from its queue, it fails once the ticket was matched
+func (m *Matchmaker) Leave(ticketID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for gameKey, queue := range m.queues {
+		for i, ticket := range queue {
+			if ticket.ID == ticketID {
+				m.queues[gameKey] = append(queue[:i], queue[i+1:]...)
+				ticket.finish(nil, ErrLeftQueue)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("ticket %s is not waiting in a queue", ticketID)
+}
+
+// Remove every ticket from the queues, their players are told why through their tickets
+func (m *Matchmaker) failAll(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for gameKey, queue := range m.queues {
+		for _, ticket := range queue {
+			ticket.finish(nil, err)
+		}
+		delete(m.queues, gameKey)
+		m.stopGathering(gameKey)
+	}
+}
+
+// Number of players waiting to play a game and how long they have been waiting
+func (m *Matchmaker) Stats(gameKey string) QueueStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := QueueStats{GameKey: gameKey, Size: len(m.queues[gameKey])}
+	if stats.Size == 0 {
+		return stats
+	}
+
+	now := m.server.Clock.Now()
+	var total time.Duration
+	for _, ticket := range m.queues[gameKey] {
+		wait := now.Sub(ticket.QueuedAt)
+		total += wait
+		if wait > stats.LongestWait {
+			stats.LongestWait = wait
+		}
+	}
+	stats.AverageWait = total / time.Duration(stats.Size)
+	return stats
+}
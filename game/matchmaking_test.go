@@ -0,0 +1,226 @@
+package game
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sebmartin/collabd/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func waitForTicket(t *testing.T, ticket *Ticket) (*PlayerCredentials, error) {
+	select {
+	case <-ticket.Done():
+		return ticket.Result()
+	case <-time.After(2 * time.Second):
+		require.Fail(t, "Timeout", "Ticket of %s was not matched", ticket.Name)
+		return nil, nil
+	}
+}
+
+func TestMatchmaker_Match(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+	clock := models.NewFakeClock(time.Now())
+	server.Clock = clock
+
+	annie, err := server.Matchmaker.Enqueue(testMatchGameName, "Annie", QueueOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, 1, server.Matchmaker.Stats(testMatchGameName).Size)
+
+	steve, err := server.Matchmaker.Enqueue(testMatchGameName, "Steve", QueueOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, 2, server.Matchmaker.Stats(testMatchGameName).Size, "The match should wait for more players")
+	clock.Advance(DefaultGatherWindow)
+
+	annieCredentials, err := waitForTicket(t, annie)
+	require.Nil(t, err)
+	steveCredentials, err := waitForTicket(t, steve)
+	require.Nil(t, err)
+	assert.Equal(t, 0, server.Matchmaker.Stats(testMatchGameName).Size)
+
+	session := annieCredentials.Player.Session
+	assert.Same(t, session, steveCredentials.Player.Session)
+	assert.False(t, session.IsPublic(), "Matches should not be listed")
+	player, err := server.Authenticate(steveCredentials.Token)
+	require.Nil(t, err)
+	assert.Equal(t, steveCredentials.Player, player)
+
+	// The player who waited the longest is the host, who starts the game
+	assert.Eventually(t, func() bool {
+		return session.CurrentStatus() == models.SessionRunning
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestMatchmaker_Skill(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+	server.Matchmaker.GatherWindow = 0
+
+	annie, _ := server.Matchmaker.Enqueue(testMatchGameName, "Annie", QueueOptions{Skill: 1000, MaxSkillGap: 100})
+	_, err := server.Matchmaker.Enqueue(testMatchGameName, "Steve", QueueOptions{Skill: 1500})
+	require.Nil(t, err)
+	// Annie doesn't mind Mikey's skill but Mikey does
+	_, err = server.Matchmaker.Enqueue(testMatchGameName, "Mikey", QueueOptions{Skill: 1050, MaxSkillGap: 10})
+	require.Nil(t, err)
+	assert.Equal(t, 3, server.Matchmaker.Stats(testMatchGameName).Size, "No players should be compatible")
+
+	lucy, _ := server.Matchmaker.Enqueue(testMatchGameName, "Lucy", QueueOptions{Skill: 1060})
+	lucyCredentials, err := waitForTicket(t, lucy)
+	require.Nil(t, err)
+	annieCredentials, err := waitForTicket(t, annie)
+	require.Nil(t, err)
+	assert.Same(t, annieCredentials.Player.Session, lucyCredentials.Player.Session)
+	// Steve is too far from Annie, and Mikey won't play with Annie
+	assert.Len(t, annieCredentials.Player.Session.Players, 2)
+	assert.Equal(t, 2, server.Matchmaker.Stats(testMatchGameName).Size)
+}
+
+func TestMatchmaker_SameName(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+
+	server.Matchmaker.Enqueue(testMatchGameName, "Steve", QueueOptions{})
+	server.Matchmaker.Enqueue(testMatchGameName, "steve", QueueOptions{})
+	assert.Equal(t, 2, server.Matchmaker.Stats(testMatchGameName).Size, "Players with the same name could not join the same session")
+}
+
+func TestMatchmaker_Leave(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+
+	annie, _ := server.Matchmaker.Enqueue(testMatchGameName, "Annie", QueueOptions{})
+	require.Nil(t, server.Matchmaker.Leave(annie.ID))
+	_, err := waitForTicket(t, annie)
+	assert.ErrorIs(t, err, ErrLeftQueue)
+	assert.Equal(t, 0, server.Matchmaker.Stats(testMatchGameName).Size)
+
+	assert.ErrorContains(t, server.Matchmaker.Leave(annie.ID), "is not waiting in a queue")
+}
+
+func TestMatchmaker_Stats(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+	clock := models.NewFakeClock(time.Now())
+	server.Clock = clock
+
+	server.Matchmaker.Enqueue(testMatchGameName, "Annie", QueueOptions{MaxSkillGap: 1})
+	clock.Advance(30 * time.Second)
+	server.Matchmaker.Enqueue(testMatchGameName, "Steve", QueueOptions{Skill: 10})
+	clock.Advance(10 * time.Second)
+
+	stats := server.Matchmaker.Stats(testMatchGameName)
+	assert.Equal(t, QueueStats{
+		GameKey:     testMatchGameName,
+		Size:        2,
+		LongestWait: 40 * time.Second,
+		AverageWait: 25 * time.Second,
+	}, stats)
+	assert.Equal(t, 40, stats.LongestWaitSeconds())
+	assert.Equal(t, QueueStats{GameKey: testGameName}, server.Matchmaker.Stats(testGameName))
+}
+
+func TestMatchmaker_Queue(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updates, err := server.Matchmaker.Queue(ctx, testMatchGameName, "Annie", QueueOptions{})
+	require.Nil(t, err)
+	update := <-updates
+	require.NotNil(t, update.Ticket)
+	assert.Nil(t, update.Match)
+
+	// The player leaves the queue once the subscription ends
+	cancel()
+	_, open := <-updates
+	assert.False(t, open)
+	assert.Equal(t, 0, server.Matchmaker.Stats(testMatchGameName).Size)
+
+	server.Matchmaker.GatherWindow = 0
+	updates, _ = server.Matchmaker.Queue(context.Background(), testMatchGameName, "Annie", QueueOptions{})
+	<-updates
+	server.Matchmaker.Enqueue(testMatchGameName, "Steve", QueueOptions{})
+	select {
+	case update = <-updates:
+		require.NotNil(t, update.Match)
+		assert.Equal(t, "Annie", update.Match.Player.Name)
+		assert.Nil(t, update.Error())
+	case <-time.After(2 * time.Second):
+		require.Fail(t, "Timeout", "Annie was not matched")
+	}
+}
+
+func TestMatchmaker_GatherWindow(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+	clock := models.NewFakeClock(time.Now())
+	server.Clock = clock
+
+	// A full match is played right away
+	annie, _ := server.Matchmaker.Enqueue(testMatchGameName, "Annie", QueueOptions{})
+	server.Matchmaker.Enqueue(testMatchGameName, "Steve", QueueOptions{})
+	clock.Advance(DefaultGatherWindow - time.Second)
+	server.Matchmaker.Enqueue(testMatchGameName, "Mikey", QueueOptions{})
+	credentials, err := waitForTicket(t, annie)
+	require.Nil(t, err)
+	assert.Len(t, credentials.Player.Session.Players, 3)
+	assert.Equal(t, 0, server.Matchmaker.Stats(testMatchGameName).Size)
+
+	// The window of the full match doesn't cut short the window of the next one
+	lucy, _ := server.Matchmaker.Enqueue(testMatchGameName, "Lucy", QueueOptions{})
+	server.Matchmaker.Enqueue(testMatchGameName, "Ricky", QueueOptions{})
+	clock.Advance(time.Second)
+	assert.Equal(t, 2, server.Matchmaker.Stats(testMatchGameName).Size)
+
+	// The players who left don't count
+	server.Matchmaker.Leave(lucy.ID)
+	clock.Advance(DefaultGatherWindow)
+	assert.Equal(t, 1, server.Matchmaker.Stats(testMatchGameName).Size)
+
+	fred, _ := server.Matchmaker.Enqueue(testMatchGameName, "Fred", QueueOptions{})
+	clock.Advance(DefaultGatherWindow)
+	credentials, err = waitForTicket(t, fred)
+	require.Nil(t, err)
+	assert.Len(t, credentials.Player.Session.Players, 2)
+}
+
+func TestMatchmaker_LeftAfterMatch(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+	server.Matchmaker.GatherWindow = 0
+
+	annie, _ := server.Matchmaker.Enqueue(testMatchGameName, "Annie", QueueOptions{})
+	server.Matchmaker.Enqueue(testMatchGameName, "Steve", QueueOptions{})
+	assert.Error(t, server.Matchmaker.Leave(annie.ID), "Annie was already matched")
+
+	// The match is not played without Annie
+	server.Matchmaker.abandon(annie)
+	credentials, err := annie.Result()
+	require.Nil(t, err)
+	session := credentials.Player.Session
+	select {
+	case <-session.Done():
+	case <-time.After(2 * time.Second):
+		require.Fail(t, "Timeout", "The match was not aborted")
+	}
+	assert.Equal(t, models.SessionAborted, session.CurrentStatus())
+}
+
+func TestMatchmaker_Enqueue_Invalid(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+
+	_, err := server.Matchmaker.Enqueue("__unknown__", "Annie", QueueOptions{})
+	assert.ErrorContains(t, err, "unknown game: __unknown__")
+	_, err = server.Matchmaker.Enqueue(testMatchGameName, "", QueueOptions{})
+	assert.ErrorContains(t, err, "a name is required")
+	_, err = server.Matchmaker.Enqueue(testMatchGameName, "Annie", QueueOptions{MaxSkillGap: -1})
+	assert.ErrorContains(t, err, "must not be negative")
+
+	require.Nil(t, server.Shutdown(context.Background()))
+	_, err = server.Matchmaker.Enqueue(testMatchGameName, "Annie", QueueOptions{})
+	assert.ErrorIs(t, err, ErrShuttingDown)
+}
@@ -0,0 +1,226 @@
+package game
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const InvalidOptionsError = "INVALID_OPTIONS"
+
+type OptionType string
+
+const (
+	IntOptionType  OptionType = "INT"
+	EnumOptionType OptionType = "ENUM"
+	BoolOptionType OptionType = "BOOL"
+)
+
+func (t OptionType) MarshalGQL(w io.Writer) {
+	io.WriteString(w, strconv.Quote(string(t)))
+}
+
+func (t *OptionType) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("option types must be strings")
+	}
+	*t = OptionType(str)
+	return nil
+}
+
+// Declares an option that players can set to configure a game when they start a session.
+// Use IntOption, EnumOption or BoolOption to declare one.
+type OptionSpec struct {
+	Key         string
+	Description string
+	Type        OptionType
+	// Value used when the option is not set, its Go type matches the option's type
+	Default interface{}
+	// Inclusive bounds of an int option
+	Min int
+	Max int
+	// Allowed values of an enum option
+	Values []string
+}
+
+func IntOption(key string, description string, defaultValue int, min int, max int) OptionSpec {
+	return OptionSpec{
+		Key:         key,
+		Description: description,
+		Type:        IntOptionType,
+		Default:     defaultValue,
+		Min:         min,
+		Max:         max,
+	}
+}
+
+func EnumOption(key string, description string, defaultValue string, values ...string) OptionSpec {
+	return OptionSpec{
+		Key:         key,
+		Description: description,
+		Type:        EnumOptionType,
+		Default:     defaultValue,
+		Values:      values,
+	}
+}
+
+func BoolOption(key string, description string, defaultValue bool) OptionSpec {
+	return OptionSpec{
+		Key:         key,
+		Description: description,
+		Type:        BoolOptionType,
+		Default:     defaultValue,
+	}
+}
+
+// Check that a game's option specs are consistent, e.g. that their defaults are allowed values
+func validateOptionSpecs(specs []OptionSpec) error {
+	keys := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		if spec.Key == "" {
+			return fmt.Errorf("options must have a key")
+		}
+		if keys[spec.Key] {
+			return fmt.Errorf("option %s is declared twice", spec.Key)
+		}
+		keys[spec.Key] = true
+		if err := spec.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (spec OptionSpec) validate() error {
+	switch spec.Type {
+	case IntOptionType:
+		value, ok := spec.Default.(int)
+		if !ok {
+			return fmt.Errorf("option %s must have an int default", spec.Key)
+		}
+		if spec.Min > spec.Max {
+			return fmt.Errorf("option %s has a minimum of %d that exceeds its maximum of %d", spec.Key, spec.Min, spec.Max)
+		}
+		if value < spec.Min || value > spec.Max {
+			return fmt.Errorf("option %s has a default of %d outside of its bounds %d and %d", spec.Key, value, spec.Min, spec.Max)
+		}
+	case EnumOptionType:
+		value, ok := spec.Default.(string)
+		if !ok {
+			return fmt.Errorf("option %s must have a string default", spec.Key)
+		}
+		for _, allowed := range spec.Values {
+			if value == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("option %s has a default of %q that is not one of %v", spec.Key, value, spec.Values)
+	case BoolOptionType:
+		if _, ok := spec.Default.(bool); !ok {
+			return fmt.Errorf("option %s must have a bool default", spec.Key)
+		}
+	default:
+		return fmt.Errorf("option %s has an unsupported type: %s", spec.Key, spec.Type)
+	}
+	return nil
+}
+
+// Validated options of a game keyed by option key. Every option declared by the game has a
+// value, the default one if it was not set.
+type Options map[string]interface{}
+
+func (o Options) Int(key string) int {
+	value, _ := o[key].(int)
+	return value
+}
+
+func (o Options) String(key string) string {
+	value, _ := o[key].(string)
+	return value
+}
+
+func (o Options) Bool(key string) bool {
+	value, _ := o[key].(bool)
+	return value
+}
+
+// Validate options encoded as a JSON object against the specs declared by a game. Options that
+// are not set take their default value.
+func ParseOptions(specs []OptionSpec, encoded json.RawMessage) (Options, error) {
+	raw := map[string]interface{}{}
+	if len(encoded) > 0 && string(encoded) != "null" {
+		decoder := json.NewDecoder(bytes.NewReader(encoded))
+		decoder.UseNumber()
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, NewValidationError(InvalidOptionsError, "options must be an object: %s", err)
+		}
+	}
+
+	options := Options{}
+	for _, spec := range specs {
+		value, found := raw[spec.Key]
+		delete(raw, spec.Key)
+		if !found {
+			options[spec.Key] = spec.Default
+			continue
+		}
+
+		parsed, err := spec.parse(value)
+		if err != nil {
+			return nil, err
+		}
+		options[spec.Key] = parsed
+	}
+
+	if len(raw) > 0 {
+		unknown := make([]string, 0, len(raw))
+		for key := range raw {
+			unknown = append(unknown, key)
+		}
+		sort.Strings(unknown)
+		return nil, NewValidationError(InvalidOptionsError, "unknown option(s): %s", strings.Join(unknown, ", "))
+	}
+	return options, nil
+}
+
+func (spec OptionSpec) parse(value interface{}) (interface{}, error) {
+	switch spec.Type {
+	case IntOptionType:
+		number, ok := value.(json.Number)
+		if !ok {
+			return nil, NewValidationError(InvalidOptionsError, "option %s must be an integer", spec.Key)
+		}
+		i, err := strconv.Atoi(number.String())
+		if err != nil {
+			return nil, NewValidationError(InvalidOptionsError, "option %s must be an integer", spec.Key)
+		}
+		if i < spec.Min || i > spec.Max {
+			return nil, NewValidationError(InvalidOptionsError, "option %s must be between %d and %d", spec.Key, spec.Min, spec.Max)
+		}
+		return i, nil
+
+	case EnumOptionType:
+		str, ok := value.(string)
+		if ok {
+			for _, allowed := range spec.Values {
+				if str == allowed {
+					return str, nil
+				}
+			}
+		}
+		return nil, NewValidationError(InvalidOptionsError, "option %s must be one of %v", spec.Key, spec.Values)
+
+	case BoolOptionType:
+		b, ok := value.(bool)
+		if !ok {
+			return nil, NewValidationError(InvalidOptionsError, "option %s must be a boolean", spec.Key)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("option %s has an unsupported type: %s", spec.Key, spec.Type)
+}
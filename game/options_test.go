@@ -0,0 +1,68 @@
+package game
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testOptionSpecs = []OptionSpec{
+	IntOption("rounds", "Number of rounds", 3, 1, 10),
+	EnumOption("color", "Color of the first player", "red", "red", "black"),
+	BoolOption("hints", "Show hints", false),
+}
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		want    Options
+	}{
+		{name: "defaults", encoded: ``, want: Options{"rounds": 3, "color": "red", "hints": false}},
+		{name: "null", encoded: `null`, want: Options{"rounds": 3, "color": "red", "hints": false}},
+		{name: "all set", encoded: `{"rounds": 10, "color": "black", "hints": true}`, want: Options{"rounds": 10, "color": "black", "hints": true}},
+		{name: "some set", encoded: `{"rounds": 1}`, want: Options{"rounds": 1, "color": "red", "hints": false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseOptions(testOptionSpecs, json.RawMessage(tt.encoded))
+			require.Nil(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseOptions_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		message string
+	}{
+		{name: "not an object", encoded: `[1, 2]`, message: "options must be an object"},
+		{name: "unknown options", encoded: `{"speed": 1, "level": 2}`, message: "unknown option(s): level, speed"},
+		{name: "int too small", encoded: `{"rounds": 0}`, message: "option rounds must be between 1 and 10"},
+		{name: "int too large", encoded: `{"rounds": 11}`, message: "option rounds must be between 1 and 10"},
+		{name: "int not integral", encoded: `{"rounds": 1.5}`, message: "option rounds must be an integer"},
+		{name: "int wrong type", encoded: `{"rounds": "3"}`, message: "option rounds must be an integer"},
+		{name: "enum unknown value", encoded: `{"color": "blue"}`, message: "option color must be one of [red black]"},
+		{name: "bool wrong type", encoded: `{"hints": "yes"}`, message: "option hints must be a boolean"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseOptions(testOptionSpecs, json.RawMessage(tt.encoded))
+			require.IsType(t, &ValidationError{}, err)
+			assert.Equal(t, InvalidOptionsError, err.(*ValidationError).Code)
+			assert.ErrorContains(t, err, tt.message)
+		})
+	}
+}
+
+func TestOptions_Getters(t *testing.T) {
+	options := Options{"rounds": 3, "color": "red", "hints": true}
+	assert.Equal(t, 3, options.Int("rounds"))
+	assert.Equal(t, "red", options.String("color"))
+	assert.True(t, options.Bool("hints"))
+	assert.Equal(t, 0, options.Int("missing"))
+}
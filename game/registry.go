@@ -1,50 +1,242 @@
 package game
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/sebmartin/collabd/models"
 )
 
 var (
 	gameRegistryMu sync.RWMutex
-	gameRegistry   = make(map[string]func(context.Context) (models.GameDescriber, error))
+	gameRegistry   = make(map[string]registeredGame)
+
+	eventRegistryMu sync.RWMutex
+	eventRegistry   = make(map[models.EventType]EventDecoder)
+
+	presenterRegistryMu sync.RWMutex
+	presenterRegistry   = make(map[models.EventType]EventPresenter)
+	// Event types that have their own GraphQL type, see RegisterTypedServerEvent()
+	typedEventRegistry = make(map[models.EventType]bool)
 )
 
-// Registers a game making it available from the server. If called twice with the
-// same game name, or game is nil, it panics.
-func Register(name string, game func(ctx context.Context) (models.GameDescriber, error)) {
+// Creates a new instance of a game for a session, configured with the options chosen by the
+// player who started the session
+type GameFactory func(ctx context.Context, options Options) (models.GameDescriber, error)
+
+// Describes a game to players who are picking a game to play
+type GameInfo struct {
+	// Unique key used to start a session for the game
+	Key               string
+	Name              string
+	Description       string
+	MinPlayers        uint
+	MaxPlayers        uint
+	EstimatedDuration time.Duration
+	Rules             string
+	// Options that can be set to configure the game when a session is started
+	Options []OptionSpec
+}
+
+// Estimated duration of a game rounded to the minute
+func (i GameInfo) EstimatedMinutes() int {
+	return int(i.EstimatedDuration.Round(time.Minute).Minutes())
+}
+
+type registeredGame struct {
+	info    GameInfo
+	factory GameFactory
+}
+
+// Builds a typed player event from the JSON payload of an action sent by a client. The sender
+// is the player who sent the action.
+type EventDecoder func(ctx context.Context, sender *models.Player, payload json.RawMessage) (models.PlayerEvent, error)
+
+// Registers a game making it available from the server under the key of its info. If called
+// twice with the same key, the info is incomplete or game is nil, it panics.
+func Register(info GameInfo, game GameFactory) {
 	gameRegistryMu.Lock()
 	defer gameRegistryMu.Unlock()
 
+	if info.Key == "" {
+		panic("Game Registry: attempted to register a game without a key")
+	}
+	if info.Name == "" {
+		panic("Game Registry: attempted to register a game without a name for key " + info.Key)
+	}
+	if info.MinPlayers > info.MaxPlayers {
+		panic("Game Registry: minimum player count exceeds the maximum for key " + info.Key)
+	}
+	if err := validateOptionSpecs(info.Options); err != nil {
+		panic("Game Registry: invalid options for key " + info.Key + ": " + err.Error())
+	}
 	if game == nil {
-		panic("Game Registry: attempted to register nil game for name " + name)
+		panic("Game Registry: attempted to register nil game for key " + info.Key)
+	}
+	if _, other := gameRegistry[info.Key]; other {
+		panic("Game Registry: a game is already registered with the key " + info.Key)
 	}
-	if _, other := gameRegistry[name]; other {
-		panic("Game Registry: a game is already registered with the name " + name)
+	gameRegistry[info.Key] = registeredGame{
+		info:    info,
+		factory: game,
 	}
-	gameRegistry[name] = game
 }
 
-func NewGame(name string, ctx context.Context) (models.GameDescriber, error) {
+// Create a new instance of a registered game. The options are validated against the option specs
+// of the game's info before they are passed to the game's factory.
+func NewGame(key string, ctx context.Context, options json.RawMessage) (models.GameDescriber, error) {
 	gameRegistryMu.RLock()
 	defer gameRegistryMu.RUnlock()
 
-	gameInit, found := gameRegistry[name]
+	registered, found := gameRegistry[key]
 	if !found {
-		return nil, fmt.Errorf("failed to create session, unknown game: %s", name)
+		return nil, fmt.Errorf("failed to create session, unknown game: %s", key)
 	}
-	return gameInit(ctx)
+
+	parsed, err := ParseOptions(registered.info.Options, options)
+	if err != nil {
+		return nil, err
+	}
+	return registered.factory(ctx, parsed)
 }
 
-func RegisteredGames() (names []string) {
+// Info of a registered game
+func gameInfo(key string) (GameInfo, error) {
 	gameRegistryMu.RLock()
 	defer gameRegistryMu.RUnlock()
 
-	for name := range gameRegistry {
-		names = append(names, name)
+	registered, found := gameRegistry[key]
+	if !found {
+		return GameInfo{}, fmt.Errorf("unknown game: %s", key)
+	}
+	return registered.info, nil
+}
+
+// Info of every registered game sorted by name, and then by key for games sharing a name.
+func RegisteredGames() []GameInfo {
+	gameRegistryMu.RLock()
+	defer gameRegistryMu.RUnlock()
+
+	games := make([]GameInfo, 0, len(gameRegistry))
+	for _, registered := range gameRegistry {
+		games = append(games, registered.info)
+	}
+	sort.Slice(games, func(i, j int) bool {
+		if games[i].Name != games[j].Name {
+			return games[i].Name < games[j].Name
+		}
+		return games[i].Key < games[j].Key
+	})
+	return games
+}
+
+// Builds the payload that is sent to clients for a game's custom server event. The payload must
+// be serializable to JSON.
+type EventPresenter func(event models.ServerEvent) (interface{}, error)
+
+// Registers a decoder for a player event type making it possible for clients to send that event
+// as an action. If called twice with the same event type, or decoder is nil, it panics.
+func RegisterEvent(eventType models.EventType, decoder EventDecoder) {
+	eventRegistryMu.Lock()
+	defer eventRegistryMu.Unlock()
+
+	if decoder == nil {
+		panic("Event Registry: attempted to register nil decoder for event type " + eventType)
+	}
+	if _, other := eventRegistry[eventType]; other {
+		panic("Event Registry: a decoder is already registered for the event type " + eventType)
+	}
+	eventRegistry[eventType] = decoder
+}
+
+// Build a typed player event from an action's payload using the decoder registered for its type.
+func DecodeEvent(ctx context.Context, eventType models.EventType, sender *models.Player, payload json.RawMessage) (models.PlayerEvent, error) {
+	eventRegistryMu.RLock()
+	decoder, found := eventRegistry[eventType]
+	eventRegistryMu.RUnlock()
+
+	if !found {
+		return nil, NewValidationError(UnknownEventTypeError, "unknown event type: %s", eventType)
+	}
+	return decoder(ctx, sender, payload)
+}
+
+// Helper for decoders that unmarshals a payload into v. Unknown fields are refused so that typos
+// in a client's payload don't go unnoticed. An empty payload is treated as an empty object.
+func DecodePayload(payload json.RawMessage, v interface{}) error {
+	if len(payload) == 0 || string(payload) == "null" {
+		payload = json.RawMessage("{}")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return NewValidationError(InvalidPayloadError, "invalid payload: %s", err)
+	}
+	return nil
+}
+
+// Registers a presenter for a game's custom server event type. Clients receive events of that
+// type as a GameEvent carrying the payload built by the presenter. If called twice with the same
+// event type, or presenter is nil, it panics.
+func RegisterServerEvent(eventType models.EventType, presenter EventPresenter) {
+	presenterRegistryMu.Lock()
+	defer presenterRegistryMu.Unlock()
+
+	if presenter == nil {
+		panic("Event Registry: attempted to register nil presenter for event type " + eventType)
+	}
+	if _, other := presenterRegistry[eventType]; other || typedEventRegistry[eventType] {
+		panic("Event Registry: a presenter is already registered for the event type " + eventType)
+	}
+	presenterRegistry[eventType] = presenter
+}
+
+// Registers a game's custom server event type that has its own GraphQL type, which gives clients a
+// typed payload. The type implements the Event interface in a schema file of the game, e.g.
+// games/connect4/connect4.graphqls, that gqlgen.yml includes and that maps the type to the event's
+// struct. Clients receive events of that type as is. If called twice with the same event type, or
+// with the type of an event that has a presenter, it panics.
+func RegisterTypedServerEvent(eventType models.EventType) {
+	presenterRegistryMu.Lock()
+	defer presenterRegistryMu.Unlock()
+
+	if _, other := presenterRegistry[eventType]; other || typedEventRegistry[eventType] {
+		panic("Event Registry: a presenter is already registered for the event type " + eventType)
+	}
+	typedEventRegistry[eventType] = true
+}
+
+// Prepare a game's custom server event for clients. Events that have their own GraphQL type are
+// returned as is, the others are wrapped in a GameEvent using the presenter registered for their
+// type. Events without a registered presenter are wrapped with an empty payload.
+func PresentEvent(event models.ServerEvent) (models.ServerEvent, error) {
+	presenterRegistryMu.RLock()
+	presenter, found := presenterRegistry[event.Type()]
+	typed := typedEventRegistry[event.Type()]
+	presenterRegistryMu.RUnlock()
+
+	if typed {
+		return event, nil
+	}
+
+	gameEvent := &models.GameEvent{ServerEvent: event}
+	if !found {
+		return gameEvent, nil
+	}
+
+	payload, err := presenter(event)
+	if err != nil {
+		return nil, err
+	}
+	gameEvent.Payload, err = json.Marshal(payload)
+	if err != nil {
+		return nil, err
 	}
-	return names
+	return gameEvent, nil
 }
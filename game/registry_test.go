@@ -0,0 +1,118 @@
+package game
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sebmartin/collabd/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	RegisterServerEvent("PRESENTED", func(event models.ServerEvent) (interface{}, error) {
+		return map[string]string{"hello": "world"}, nil
+	})
+	RegisterServerEvent("NOT_PRESENTABLE", func(event models.ServerEvent) (interface{}, error) {
+		return nil, fmt.Errorf("cannot present this event")
+	})
+	RegisterTypedServerEvent("TYPED")
+}
+
+func TestPresentEvent(t *testing.T) {
+	event := models.NewServerEvent("PRESENTED")
+	presented, err := PresentEvent(event)
+	require.Nil(t, err)
+	require.IsType(t, &models.GameEvent{}, presented)
+	assert.Equal(t, models.EventType("PRESENTED"), presented.Type())
+	assert.JSONEq(t, `{"hello": "world"}`, string(presented.(*models.GameEvent).Payload))
+}
+
+func TestPresentEvent_NoPresenter(t *testing.T) {
+	presented, err := PresentEvent(models.NewServerEvent("UNREGISTERED"))
+	require.Nil(t, err)
+	require.IsType(t, &models.GameEvent{}, presented)
+	assert.Equal(t, models.EventType("UNREGISTERED"), presented.Type())
+	assert.Nil(t, presented.(*models.GameEvent).Payload)
+}
+
+func TestPresentEvent_Typed(t *testing.T) {
+	event := models.NewServerEvent("TYPED")
+	presented, err := PresentEvent(event)
+	require.Nil(t, err)
+	assert.Same(t, event, presented)
+}
+
+func TestPresentEvent_Error(t *testing.T) {
+	_, err := PresentEvent(models.NewServerEvent("NOT_PRESENTABLE"))
+	assert.ErrorContains(t, err, "cannot present this event")
+}
+
+func TestRegisterServerEvent_Duplicate(t *testing.T) {
+	assert.Panics(t, func() {
+		RegisterServerEvent("PRESENTED", func(event models.ServerEvent) (interface{}, error) {
+			return nil, nil
+		})
+	})
+	assert.Panics(t, func() {
+		RegisterServerEvent("TYPED", func(event models.ServerEvent) (interface{}, error) {
+			return nil, nil
+		})
+	})
+	assert.Panics(t, func() { RegisterTypedServerEvent("TYPED") })
+	assert.Panics(t, func() { RegisterTypedServerEvent("PRESENTED") })
+}
+
+func TestRegisteredGames_Sorted(t *testing.T) {
+	games := RegisteredGames()
+	require.GreaterOrEqual(t, len(games), 2)
+	for i := 1; i < len(games); i++ {
+		previous, current := games[i-1], games[i]
+		assert.True(t,
+			previous.Name < current.Name || (previous.Name == current.Name && previous.Key < current.Key),
+			"%s (%s) is listed before %s (%s)", previous.Name, previous.Key, current.Name, current.Key,
+		)
+	}
+}
+
+func TestRegister_InvalidInfo(t *testing.T) {
+	factory := func(ctx context.Context, options Options) (models.GameDescriber, error) {
+		return nil, nil
+	}
+	tests := []struct {
+		name string
+		info GameInfo
+	}{
+		{name: "missing key", info: GameInfo{Name: "No Key"}},
+		{name: "missing name", info: GameInfo{Key: "__no_name__"}},
+		{name: "min exceeds max", info: GameInfo{Key: "__bad_counts__", Name: "Bad Counts", MinPlayers: 3, MaxPlayers: 2}},
+		{name: "duplicate key", info: GameInfo{Key: testGameName, Name: "Duplicate"}},
+		{name: "int default out of bounds", info: optionsInfo(IntOption("rounds", "", 0, 1, 10))},
+		{name: "int bounds inverted", info: optionsInfo(IntOption("rounds", "", 5, 10, 1))},
+		{name: "enum default not allowed", info: optionsInfo(EnumOption("color", "", "green", "red", "blue"))},
+		{name: "enum without values", info: optionsInfo(EnumOption("color", "", "red"))},
+		{name: "wrong default type", info: optionsInfo(OptionSpec{Key: "fast", Type: BoolOptionType, Default: "yes"})},
+		{name: "unknown type", info: optionsInfo(OptionSpec{Key: "fast", Type: "FLOAT", Default: 1.5})},
+		{name: "missing option key", info: optionsInfo(BoolOption("", "", false))},
+		{name: "duplicate option key", info: optionsInfo(BoolOption("fast", "", false), BoolOption("fast", "", true))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Panics(t, func() {
+				Register(tt.info, factory)
+			})
+		})
+	}
+}
+
+// Info of a game that is never registered successfully, it only declares options
+func optionsInfo(options ...OptionSpec) GameInfo {
+	return GameInfo{Key: "__invalid_options__", Name: "Invalid Options", Options: options}
+}
+
+func TestGameInfo_EstimatedMinutes(t *testing.T) {
+	info := GameInfo{EstimatedDuration: 90 * time.Second}
+	assert.Equal(t, 2, info.EstimatedMinutes())
+}
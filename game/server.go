@@ -2,9 +2,14 @@ package game
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/json"
 	"fmt"
+	"log"
 	"sync"
+	"time"
 
+	"github.com/sebmartin/collabd/game/join_stage"
 	"github.com/sebmartin/collabd/models"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
@@ -12,10 +17,49 @@ import (
 	"gorm.io/gorm"
 )
 
+const (
+	// Maximum amount of time to wait for a session to acknowledge a join request
+	JoinTimeout = 5 * time.Second
+	// Default amount of time a session is kept around after it has ended
+	DefaultSessionGracePeriod = 5 * time.Minute
+	// Default amount of time a player token remains valid
+	DefaultTokenTTL = 24 * time.Hour
+)
+
+var (
+	// Returned when joining a session whose game is past its lobby
+	ErrGameStarted = NewValidationError(GameStartedError, "the game has already started")
+	// Returned when joining a session whose lobby is full or locked
+	ErrNotAcceptingPlayers = NewValidationError(NotAcceptingPlayersError, "the session is not accepting players")
+)
+
 type Server struct {
+	// Key used to sign player tokens. A random key is generated by NewServer, set a stable key to
+	// keep tokens valid across server restarts.
+	TokenSecret []byte
+	// Amount of time a player token remains valid after it was issued, players must join again
+	// to get a new one
+	TokenTTL time.Duration
+	// Amount of time a session that has ended can still be looked up, e.g. for players to see how
+	// it ended, before it is removed from the server.
+	SessionGracePeriod time.Duration
+	// Clock that drives the timers of the sessions, tests can replace it with a models.FakeClock
+	Clock models.Clock
+	// Generates the codes of new sessions, e.g. a models.RandomCodeGenerator with a different
+	// alphabet or length
+	SessionCodes models.CodeGenerator
+	// Starts sessions for players who queue to play a game
+	Matchmaker *Matchmaker
+
 	db         *gorm.DB
 	sessionsMu sync.RWMutex
 	sessions   []*models.Session
+	// Set by Shutdown, no sessions are created or joined afterwards
+	shuttingDown bool
+
+	// Recent wrong passcodes given for each session by each client, see checkPasscode()
+	passcodeAttemptsMu sync.Mutex
+	passcodeAttempts   map[passcodeAttemptKey][]*time.Time
 }
 
 func NewServer(driverName string, dsn string) (*Server, error) {
@@ -43,29 +87,108 @@ func NewServer(driverName string, dsn string) (*Server, error) {
 	err = gormDB.AutoMigrate(
 		&models.Player{},
 		&models.Session{},
+		&models.SessionEvent{},
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Server{
-		db: gormDB,
-	}, nil
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	server := &Server{
+		TokenSecret:        secret,
+		TokenTTL:           DefaultTokenTTL,
+		SessionGracePeriod: DefaultSessionGracePeriod,
+		Clock:              models.SystemClock,
+		SessionCodes:       models.DefaultCodeGenerator,
+		db:                 gormDB,
+	}
+	server.Matchmaker = newMatchmaker(server)
+	if err := server.restoreSessions(); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// Resume the sessions that were still live when the server last stopped. The games must be
+// registered before the server is created for their sessions to be restored, the sessions that
+// can't be restored are aborted.
+func (s *Server) restoreSessions() error {
+	sessions, err := models.LiveSessions(s.db)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		game, err := NewGame(session.GameKey, context.Background(), json.RawMessage(session.GameOptions))
+		if err != nil {
+			models.AbortSession(s.db, session)
+			log.Printf(`Could not restore session "%s": %s`, session.Code, err)
+			continue
+		}
+		if err := models.ResumeSession(s.db, session, game, s.Clock); err != nil {
+			log.Printf(`Could not restore session "%s": %s`, session.Code, err)
+			continue
+		}
+		s.appendSession(session)
+		go s.reapSession(session)
+	}
+	return nil
+}
+
+// Start a new public session for a game. This will create a new instance of a game configured
+// with the given options and execute the initial stage runner.
+func (s *Server) NewSession(ctx context.Context, gameName string, options json.RawMessage) (*models.Session, error) {
+	return s.newSession(ctx, gameName, options, models.SessionPublic, "")
+}
+
+// Start a new session for a game that is not listed by ListedSessions(). Players join it with its
+// code, and its passcode unless it is empty, or with an invite issued by IssueInvite().
+func (s *Server) NewPrivateSession(ctx context.Context, gameName string, options json.RawMessage, passcode string) (*models.Session, error) {
+	return s.newSession(ctx, gameName, options, models.SessionPrivate, passcode)
 }
 
-// Start a new session for a game. This will create a new instance of a game and execute the initial
-// stage runner.
-func (s *Server) NewSession(ctx context.Context, gameName *string) (*models.Session, error) {
-	game, err := NewGame(*gameName, ctx)
+func (s *Server) newSession(ctx context.Context, gameName string, options json.RawMessage, visibility models.SessionVisibility, passcode string) (*models.Session, error) {
+	if s.isShuttingDown() {
+		return nil, ErrShuttingDown
+	}
+
+	var passcodeHash string
+	if passcode != "" {
+		hash, err := models.HashPasscode(passcode)
+		if err != nil {
+			return nil, err
+		}
+		passcodeHash = hash
+	}
+
+	game, err := NewGame(gameName, ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := models.NewSession(s.db, game, s.SessionCodes, s.Clock)
 	if err != nil {
 		return nil, err
 	}
 
-	session, err := models.NewSession(s.db, game)
+	// Remember how the game was created so that the session can be restored after a restart
+	session.GameKey, session.GameOptions = gameName, models.JSON(options)
+	session.Visibility, session.PasscodeHash = visibility, passcodeHash
+	err = s.db.Model(&models.Session{}).Where("id = ?", session.ID).Updates(map[string]interface{}{
+		"game_key":      session.GameKey,
+		"game_options":  session.GameOptions,
+		"visibility":    session.Visibility,
+		"passcode_hash": session.PasscodeHash,
+	}).Error
 	if err != nil {
 		return nil, err
 	}
 	s.appendSession(session)
+	go s.reapSession(session)
 	return session, nil
 }
 
@@ -79,27 +202,257 @@ func (s *Server) appendSession(session *models.Session) {
 	s.sessions = append(s.sessions, session)
 }
 
-func (s *Server) ActiveSessions() []*models.Session {
+// Remove a session from the server once it has ended and its grace period has elapsed
+func (s *Server) reapSession(session *models.Session) {
+	<-session.Done()
+	s.Clock.AfterFunc(s.SessionGracePeriod, func() {
+		s.removeSession(session)
+		s.forgetPasscodeAttempts(session.ID)
+	})
+}
+
+func (s *Server) removeSession(session *models.Session) {
 	s.sessionsMu.Lock()
 	defer s.sessionsMu.Unlock()
-	return s.sessions
+
+	for i, existing := range s.sessions {
+		if existing == session {
+			s.sessions = append(s.sessions[:i], s.sessions[i+1:]...)
+			return
+		}
+	}
+}
+
+// Sessions that have not ended yet
+func (s *Server) ActiveSessions() []*models.Session {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+
+	var active []*models.Session
+	for _, session := range s.sessions {
+		select {
+		case <-session.Done():
+		default:
+			active = append(active, session)
+		}
+	}
+	return active
 }
 
-// Lookup existing sessions by code and return it.
+// Public sessions that players can join, e.g. to list them in a game browser. Private sessions and
+// sessions whose game has started or whose lobby is full are left out.
+func (s *Server) ListedSessions(ctx context.Context) ([]*models.Session, error) {
+	var listed []*models.Session
+	for _, session := range s.ActiveSessions() {
+		if !session.IsPublic() || session.CurrentStatus() != models.SessionLobby {
+			continue
+		}
+		state, err := session.State(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if state.AcceptingPlayers {
+			listed = append(listed, session)
+		}
+	}
+	return listed, nil
+}
+
+// Lookup existing sessions by code and return it. Codes are reused once sessions end, the session
+// that has not ended is preferred over those that ended with the same code.
 func (s *Server) SessionForCode(code string) (*models.Session, error) {
 	s.sessionsMu.RLock()
 	defer s.sessionsMu.RUnlock()
 
+	var ended *models.Session
 	for _, s := range s.sessions {
-		if s.Code == code {
+		if s.Code != code {
+			continue
+		}
+		select {
+		case <-s.Done():
+			ended = s
+		default:
 			return s, nil
 		}
 	}
+	if ended != nil {
+		return ended, nil
+	}
 	return nil, fmt.Errorf(`could not find session with code "%s"`, code)
 }
 
+// Lookup existing sessions by ID and return it.
+func (s *Server) SessionForID(id uint) (*models.Session, error) {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+
+	for _, s := range s.sessions {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf(`could not find session with id %d`, id)
+}
+
+// Create a new player and add it to the session with the given code. The passcode is only checked
+// when the session has one, too many wrong passcodes and the session refuses any passcode from the
+// client for a while, see MaxPasscodeAttempts and WithClient(). The join request is handled by the session's current stage, this
+// returns once the stage has either accepted or refused it. The player's credentials include the
+// token it needs to act in the session.
+func (s *Server) JoinSession(ctx context.Context, code string, name string, passcode string) (*PlayerCredentials, error) {
+	if s.isShuttingDown() {
+		return nil, ErrShuttingDown
+	}
+
+	session, err := s.SessionForCode(code)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkPasscode(session, clientFromContext(ctx), passcode); err != nil {
+		return nil, err
+	}
+	return s.join(ctx, session, name)
+}
+
+// Create a new player and add it to the session that an invite issued by IssueInvite() was issued
+// for. Invites let players join without the session's passcode.
+func (s *Server) JoinSessionWithInvite(ctx context.Context, invite string, name string) (*PlayerCredentials, error) {
+	if s.isShuttingDown() {
+		return nil, ErrShuttingDown
+	}
+
+	session, err := s.sessionForInvite(invite)
+	if err != nil {
+		return nil, err
+	}
+	return s.join(ctx, session, name)
+}
+
+func (s *Server) join(ctx context.Context, session *models.Session, name string) (*PlayerCredentials, error) {
+	if sessionEnded(session) {
+		return nil, models.ErrSessionEnded
+	}
+	// Stages that are past the lobby don't answer join requests
+	if session.CurrentStatus() != models.SessionLobby {
+		return nil, ErrGameStarted
+	}
+	state, err := session.State(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !state.AcceptingPlayers {
+		return nil, ErrNotAcceptingPlayers
+	}
+
+	player, err := models.NewPlayer(s.db, name)
+	if err != nil {
+		return nil, err
+	}
+	player.Session = session
+
+	if err := session.HandlePlayerEvent(join_stage.NewJoinEvent(ctx, player)); err != nil {
+		s.discardPlayer(player)
+		return nil, err
+	}
+
+	refusal, err := awaitJoin(ctx, session, player, time.After(JoinTimeout))
+	if refusal != nil {
+		s.discardPlayer(player)
+		return nil, refusal
+	}
+	if err != nil {
+		go s.abandonJoin(session, player)
+		return nil, err
+	}
+
+	// The stage added the player to the session when it accepted them
+	token, err := s.IssueToken(player)
+	if err != nil {
+		return nil, err
+	}
+	return &PlayerCredentials{Player: player, Token: token}, nil
+}
+
+// Wait for the session's stage to answer a player's join request. The refusal is returned when
+// the stage refuses it, an error is returned when the request is given up on before it is
+// answered. The answer is read from the player's history, the player's channel is left untouched
+// for whoever reads it once the player has joined.
+func awaitJoin(ctx context.Context, session *models.Session, player *models.Player, timeout <-chan time.Time) (refusal error, err error) {
+	var last uint
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		updated := session.HistoryUpdated(player)
+		events, _ := session.History(player, last)
+		for _, event := range events {
+			last = event.Sequence()
+			switch event := event.(type) {
+			case *join_stage.DidJoinEvent:
+				if event.Player == player {
+					return nil, nil
+				}
+			case *models.ErrorEvent:
+				return event.Error, nil
+			}
+		}
+
+		select {
+		case <-updated:
+		case <-session.Done():
+			return nil, fmt.Errorf(`session "%s" has ended`, session.Code)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timeout:
+			return nil, fmt.Errorf(`session "%s" did not respond to the join request`, session.Code)
+		}
+	}
+}
+
+// Undo a join request that was given up on, e.g. when the client disconnected. The stage may
+// still accept it, the player then leaves right away to free its seat. The stage is given another
+// JoinTimeout to answer.
+func (s *Server) abandonJoin(session *models.Session, player *models.Player) {
+	refusal, err := awaitJoin(context.Background(), session, player, time.After(JoinTimeout))
+	if refusal != nil || err != nil {
+		s.discardPlayer(player)
+		return
+	}
+	session.HandlePlayerEvent(join_stage.NewLeaveEvent(context.Background(), player))
+}
+
+// Whether a session's last stage has ended, or the session was stopped
+func sessionEnded(session *models.Session) bool {
+	select {
+	case <-session.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// Delete a player who never made it into a session
+func (s *Server) discardPlayer(player *models.Player) {
+	if err := s.db.Delete(player).Error; err != nil {
+		log.Printf("Failed to delete player %d: %s", player.ID, err)
+	}
+}
+
+// Keys of the registered games, prefer Games() which describes each game.
 func (s *Server) GamesList() ([]string, error) {
-	return RegisteredGames(), nil
+	games := RegisteredGames()
+	keys := make([]string, 0, len(games))
+	for _, info := range games {
+		keys = append(keys, info.Key)
+	}
+	return keys, nil
+}
+
+// Info of the registered games in a stable order
+func (s *Server) Games() []GameInfo {
+	return RegisteredGames()
 }
 
 func (s *Server) HandlePlayerEvent(sessionCode string, event models.PlayerEvent) error {
@@ -107,12 +460,106 @@ func (s *Server) HandlePlayerEvent(sessionCode string, event models.PlayerEvent)
 	if err != nil {
 		return err
 	}
-	session.HandlePlayerEvent(event)
-	return nil
+	return session.HandlePlayerEvent(event)
 }
 
-func Broadcast(players []*models.Player, event models.ServerEvent) {
-	for _, p := range players {
-		p.ServerEvents <- event
+// Watch a session without joining it. Spectators receive a snapshot of the session followed by
+// its public events, the returned channel is closed once the context or the session is done.
+// Private sessions can only be watched with an access to them, see AccessSession().
+func (s *Server) SpectateSession(ctx context.Context, code string, access SessionAccess) (<-chan models.ServerEvent, error) {
+	session, err := s.AccessSession(ctx, code, access)
+	if err != nil {
+		return nil, err
+	}
+	return session.Spectate(ctx)
+}
+
+// Stream the server events sent to a player of a session, starting with those sent after the
+// given sequence number. A reconnecting client passes the sequence of the last event it received
+// to catch up on the events it missed, it receives a SnapshotEvent of the session instead if some
+// of them are no longer in the player's history. The returned channel is closed once the context
+// is done, which happens when a subscribed client disconnects, or once the session has ended and
+// its last events were sent.
+func (s *Server) SubscribePlayerEvents(ctx context.Context, player *models.Player, afterSequence uint) (<-chan models.ServerEvent, error) {
+	if player.Session == nil {
+		return nil, fmt.Errorf("player %s has not joined a session", player.Name)
 	}
+
+	events := make(chan models.ServerEvent)
+	go func() {
+		defer close(events)
+		last := afterSequence
+		ended := false
+		for {
+			// Taken before reading the history so that no event is missed in between
+			updated := player.Session.HistoryUpdated(player)
+			missed, err := catchUp(ctx, player, last)
+			if err != nil {
+				return
+			}
+			for _, event := range missed {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+				if event.Sequence() > last {
+					last = event.Sequence()
+				}
+			}
+			if ended {
+				return
+			}
+
+			select {
+			case <-updated:
+			case <-player.Session.Done():
+				// Catch up one last time on the events sent as the session ended
+				ended = true
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// Events sent to a player after a sequence number, preceded by a snapshot of the session if some
+// of them are no longer in the player's history
+func catchUp(ctx context.Context, player *models.Player, afterSequence uint) ([]models.ServerEvent, error) {
+	session := player.Session
+	missed, complete := session.History(player, afterSequence)
+	if complete {
+		return missed, nil
+	}
+
+	snapshot, err := session.Snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	missed, _ = session.History(player, snapshot.Sequence())
+	return append([]models.ServerEvent{snapshot}, missed...), nil
+}
+
+// Decode an action sent by a player into a typed player event and pass it on to the player's
+// session. The player is always the event's sender, it should be the authenticated player who sent
+// the action. The event's type must have a decoder registered with RegisterEvent.
+func (s *Server) HandleAction(ctx context.Context, player *models.Player, eventType models.EventType, payload json.RawMessage) error {
+	if player.Session == nil {
+		return fmt.Errorf("player %s has not joined a session", player.Name)
+	}
+	if sessionEnded(player.Session) {
+		return models.ErrSessionEnded
+	}
+
+	event, err := DecodeEvent(ctx, eventType, player, payload)
+	if err != nil {
+		return err
+	}
+	return player.Session.HandlePlayerEvent(event)
+}
+
+// Send a public event to players and to the spectators of their session
+func Broadcast(players []*models.Player, event models.ServerEvent) {
+	models.Broadcast(players, event)
 }
@@ -2,25 +2,43 @@ package game
 
 import (
 	"context"
+	"encoding/json"
 	"os"
 	"path"
 	"testing"
 	"time"
 
+	"github.com/sebmartin/collabd/game/join_stage"
 	"github.com/sebmartin/collabd/models"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gorm.io/gorm"
 )
 
-const testGameName = "__test_game__"
+const (
+	testGameName     = "__test_game__"
+	testJoinGameName = "__test_join_game__"
+	// A game whose only stage ends right away
+	testEndingGameName = "__test_ending_game__"
+	// A game for two or three players
+	testMatchGameName = "__test_match_game__"
+)
 
 func init() {
 	registerTestGame()
+	RegisterEvent("ECHO", func(ctx context.Context, sender *models.Player, payload json.RawMessage) (models.PlayerEvent, error) {
+		var args struct {
+			Message string `json:"message"`
+		}
+		if err := DecodePayload(payload, &args); err != nil {
+			return nil, err
+		}
+		return newEchoEvent(ctx, args.Message, sender), nil
+	})
 }
 
 func registerTestGame() {
-	Register(testGameName, func(ctx context.Context) (models.GameDescriber, error) {
+	Register(GameInfo{Key: testGameName, Name: "Test Game"}, func(ctx context.Context, options Options) (models.GameDescriber, error) {
 		return testGame{
 			Game: *models.NewGame(
 				"Test Game",
@@ -28,6 +46,22 @@ func registerTestGame() {
 			),
 		}, nil
 	})
+	Register(GameInfo{Key: testJoinGameName, Name: "Test Join Game", MinPlayers: 1, MaxPlayers: 1}, func(ctx context.Context, options Options) (models.GameDescriber, error) {
+		return &testJoinGame{
+			Game: models.NewGame("Test Join Game", newTestJoinStage()),
+		}, nil
+	})
+	Register(GameInfo{Key: testMatchGameName, Name: "Test Match Game", MinPlayers: 2, MaxPlayers: 3}, func(ctx context.Context, options Options) (models.GameDescriber, error) {
+		stage := newTestJoinStage()
+		stage.MinPlayers, stage.MaxPlayers = 2, 3
+		return models.NewGame("Test Match Game", stage), nil
+	})
+	Register(GameInfo{Key: testEndingGameName, Name: "Test Ending Game"}, func(ctx context.Context, options Options) (models.GameDescriber, error) {
+		return models.NewGame(
+			"Test Ending Game",
+			&endingStage{},
+		), nil
+	})
 }
 
 func newServer(t *testing.T) (*Server, func()) {
@@ -50,7 +84,7 @@ func newServer(t *testing.T) (*Server, func()) {
 func newServerSession(t *testing.T) (*Server, *models.Session, func()) {
 	server, cleanup := newServer(t)
 
-	session, _ := server.NewSession(context.Background(), testGameName)
+	session, _ := server.NewSession(context.Background(), testGameName, nil)
 	return server, session, cleanup
 }
 
@@ -72,11 +106,27 @@ func TestServer_NewSession_SessionForCode(t *testing.T) {
 	assert.Equal(t, session, fetched)
 }
 
+func TestServer_SessionForCode_Reused(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+	server.SessionCodes = &models.RandomCodeGenerator{Length: 1, Alphabet: "X"}
+
+	ended, _ := server.NewSession(context.Background(), testEndingGameName, nil)
+	<-ended.Done()
+	session, err := server.NewSession(context.Background(), testGameName, nil)
+	require.Nil(t, err)
+	assert.Equal(t, "X", session.Code)
+
+	fetched, err := server.SessionForCode("X")
+	require.Nil(t, err)
+	assert.Equal(t, session, fetched, "The session that has not ended should be found")
+}
+
 func TestServer_NewSession_UnknownGame(t *testing.T) {
 	server, cleanup := newServer(t)
 	defer cleanup()
 
-	_, err := server.NewSession(context.Background(), "UNKNOWN_GAME_NAME")
+	_, err := server.NewSession(context.Background(), "UNKNOWN_GAME_NAME", nil)
 	assert.NotNil(t, err)
 	assert.ErrorContains(t, err, `failed to create session, unknown game: UNKNOWN_GAME_NAME`)
 }
@@ -120,6 +170,469 @@ func TestServer_HandlePlayerEvent_UnknownCode(t *testing.T) {
 	assert.ErrorContains(t, result, `could not find session with code "XXXX"`)
 }
 
+func TestServer_JoinSession(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+
+	session, _ := server.NewSession(context.Background(), testJoinGameName, nil)
+	credentials, err := server.JoinSession(context.Background(), session.Code, "Steve", "")
+	require.Nil(t, err)
+	assert.NotEmpty(t, credentials.Token)
+
+	player := credentials.Player
+	assert.Equal(t, "Steve", player.Name)
+	assert.Equal(t, session, player.Session)
+
+	found, err := session.PlayerForID(player.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, player, found)
+}
+
+func TestServer_JoinSession_Refused(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+
+	session, _ := server.NewSession(context.Background(), testMatchGameName, nil)
+	_, err := server.JoinSession(context.Background(), session.Code, "Steve", "")
+	require.Nil(t, err)
+
+	_, err = server.JoinSession(context.Background(), session.Code, "steve", "")
+	assert.ErrorContains(t, err, `the name "steve" is already taken`)
+	assert.Len(t, session.CurrentPlayers(), 1)
+
+	var count int64
+	require.Nil(t, server.db.Model(&models.Player{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count, "The refused player should not be kept")
+
+	// Once the lobby is full, joins are refused without asking the stage
+	for _, name := range []string{"Annie", "Mikey"} {
+		_, err = server.JoinSession(context.Background(), session.Code, name, "")
+		require.Nil(t, err)
+	}
+	_, err = server.JoinSession(context.Background(), session.Code, "Lucy", "")
+	assert.ErrorIs(t, err, ErrNotAcceptingPlayers)
+}
+
+func TestServer_JoinSession_AddedByStage(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+
+	session, _ := server.NewSession(context.Background(), testMatchGameName, nil)
+	host, err := server.JoinSession(context.Background(), session.Code, "Annie", "")
+	require.Nil(t, err)
+	spectator, err := session.Spectate(context.Background())
+	require.Nil(t, err)
+	<-spectator // Snapshot
+
+	// The player is part of the session by the time anyone hears of them
+	steve := newPlayer(server.db, "Steve")
+	steve.Session = session
+	require.Nil(t, session.HandlePlayerEvent(join_stage.NewJoinEvent(context.Background(), steve)))
+	select {
+	case event := <-spectator:
+		require.IsType(t, &join_stage.DidJoinEvent{}, event)
+		assert.Contains(t, session.CurrentPlayers(), steve)
+	case <-time.After(time.Second):
+		require.Fail(t, "Timeout", "Steve did not join")
+	}
+
+	// Nothing adds the player back once they are kicked
+	require.Nil(t, session.HandlePlayerEvent(join_stage.NewKickEvent(context.Background(), host.Player, steve.ID)))
+	_, err = session.State(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, []*models.Player{host.Player}, session.CurrentPlayers())
+}
+
+func TestServer_JoinSession_Started(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+
+	session, _ := server.NewSession(context.Background(), testMatchGameName, nil)
+	var host *models.Player
+	for _, name := range []string{"Annie", "Steve"} {
+		credentials, err := server.JoinSession(context.Background(), session.Code, name, "")
+		require.Nil(t, err)
+		if host == nil {
+			host = credentials.Player
+		}
+	}
+	require.Nil(t, session.HandlePlayerEvent(join_stage.NewStartEvent(context.Background(), host)))
+	require.Eventually(t, func() bool {
+		return session.CurrentStatus() == models.SessionRunning
+	}, time.Second, 10*time.Millisecond)
+
+	start := time.Now()
+	_, err := server.JoinSession(context.Background(), session.Code, "Mikey", "")
+	assert.ErrorIs(t, err, ErrGameStarted)
+	assert.Less(t, time.Since(start), JoinTimeout, "The join should be refused right away")
+}
+
+func TestServer_JoinSession_Ended(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+
+	session, _ := server.NewSession(context.Background(), testEndingGameName, nil)
+	select {
+	case <-session.Done():
+	case <-time.After(time.Second):
+		require.Fail(t, "Timeout", "The session did not end")
+	}
+
+	// More requests than the session's buffer holds, none of them waits
+	for i := 0; i <= models.ChanBufferSize; i++ {
+		_, err := server.JoinSession(context.Background(), session.Code, "Steve", "")
+		require.ErrorIs(t, err, models.ErrSessionEnded)
+	}
+	var count int64
+	require.Nil(t, server.db.Model(&models.Player{}).Count(&count).Error)
+	assert.Zero(t, count, "No player should be created")
+
+	player := newPlayer(server.db, "Steve")
+	player.Session = session
+	err := server.HandleAction(context.Background(), player, "ECHO", json.RawMessage(`{"message": "Anyone?"}`))
+	assert.ErrorIs(t, err, models.ErrSessionEnded)
+}
+
+func TestServer_JoinSession_Abandoned(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+
+	session, _ := server.NewSession(context.Background(), testJoinGameName, nil)
+	spectator, err := session.Spectate(context.Background())
+	require.Nil(t, err)
+	<-spectator // Snapshot
+
+	// The client went away before its request was sent
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = server.JoinSession(ctx, session.Code, "Steve", "")
+	require.ErrorIs(t, err, context.Canceled)
+
+	// The client went away after sending its request, the stage still seats the player who leaves
+	// right away
+	player := newPlayer(server.db, "Steve")
+	player.Session = session
+	require.Nil(t, session.HandlePlayerEvent(join_stage.NewJoinEvent(context.Background(), player)))
+	server.abandonJoin(session, player)
+	for _, expected := range []interface{}{&join_stage.DidJoinEvent{}, &join_stage.DidLeaveEvent{}} {
+		select {
+		case event := <-spectator:
+			require.IsType(t, expected, event)
+		case <-time.After(500 * time.Millisecond):
+			require.Fail(t, "Timeout", "Expected a %T", expected)
+		}
+	}
+
+	credentials, err := server.JoinSession(context.Background(), session.Code, "Annie", "")
+	require.Nil(t, err, "The seat should have been freed")
+	assert.Equal(t, []*models.Player{credentials.Player}, session.Players)
+}
+
+func TestServer_LeaveSession(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+
+	session, _ := server.NewSession(context.Background(), testJoinGameName, nil)
+	credentials, err := server.JoinSession(context.Background(), session.Code, "Steve", "")
+	require.Nil(t, err)
+
+	err = server.HandleAction(context.Background(), credentials.Player, join_stage.LeaveEventType, nil)
+	require.Nil(t, err)
+	_, err = session.State(context.Background())
+	require.Nil(t, err)
+	assert.Empty(t, session.Players)
+
+	// The spot that was freed can be taken
+	_, err = server.JoinSession(context.Background(), session.Code, "Annie", "")
+	assert.Nil(t, err)
+}
+
+func TestServer_JoinSession_UnknownCode(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+
+	_, err := server.JoinSession(context.Background(), "XXXX", "Steve", "")
+	assert.ErrorContains(t, err, `could not find session with code "XXXX"`)
+}
+
+func TestServer_HandleAction(t *testing.T) {
+	server, session, cleanup := newServerSession(t)
+	defer cleanup()
+
+	player := newPlayer(server.db, "Steve")
+	session.AddPlayer(player)
+
+	err := server.HandleAction(context.Background(), player, "ECHO", json.RawMessage(`{"message": "Well hello there!"}`))
+	require.Nil(t, err)
+
+	select {
+	case serverEvent := <-player.ServerEvents:
+		require.IsType(t, &echoEchoEvent{}, serverEvent)
+		original := serverEvent.(*echoEchoEvent).OriginalEvent
+		assert.Equal(t, "Well hello there!", original.Message)
+		assert.Equal(t, player, original.Sender())
+	case <-time.After(500 * time.Millisecond):
+		require.Fail(t, "Timeout", "Did not receive expected server event before timeout")
+	}
+}
+
+func TestServer_HandleAction_ValidationErrors(t *testing.T) {
+	server, session, cleanup := newServerSession(t)
+	defer cleanup()
+
+	player := newPlayer(server.db, "Steve")
+	session.AddPlayer(player)
+
+	tests := []struct {
+		name      string
+		eventType models.EventType
+		payload   string
+		code      string
+		message   string
+	}{
+		{name: "unknown type", eventType: "UNKNOWN", payload: `{}`, code: UnknownEventTypeError, message: "unknown event type: UNKNOWN"},
+		{name: "unknown field", eventType: "ECHO", payload: `{"msg": "hi"}`, code: InvalidPayloadError, message: `unknown field "msg"`},
+		{name: "wrong type", eventType: "ECHO", payload: `{"message": 3}`, code: InvalidPayloadError, message: "invalid payload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := server.HandleAction(context.Background(), player, tt.eventType, json.RawMessage(tt.payload))
+			require.IsType(t, &ValidationError{}, err)
+			assert.Equal(t, tt.code, err.(*ValidationError).Code)
+			assert.ErrorContains(t, err, tt.message)
+		})
+	}
+}
+
+func TestServer_HandleAction_NoSession(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+
+	player := newPlayer(server.db, "Steve")
+	err := server.HandleAction(context.Background(), player, "ECHO", json.RawMessage(`{}`))
+	assert.ErrorContains(t, err, "has not joined a session")
+}
+
+func TestServer_SubscribePlayerEvents(t *testing.T) {
+	server, session, cleanup := newServerSession(t)
+	defer cleanup()
+
+	player := newPlayer(server.db, "Steve")
+	session.AddPlayer(player)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := server.SubscribePlayerEvents(ctx, player, 0)
+	require.Nil(t, err)
+
+	echoEvent := newEchoEvent(context.Background(), "Well hello there!", player)
+	server.HandlePlayerEvent(session.Code, echoEvent)
+
+	select {
+	case event := <-events:
+		assert.IsType(t, &echoEchoEvent{}, event)
+	case <-time.After(500 * time.Millisecond):
+		require.Fail(t, "Timeout", "Did not receive expected server event before timeout")
+	}
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		_, open := <-events
+		return !open
+	}, 500*time.Millisecond, 10*time.Millisecond, "Subscription channel was not closed after the context was done")
+}
+
+func TestServer_SubscribePlayerEvents_Concurrent(t *testing.T) {
+	server, session, cleanup := newServerSession(t)
+	defer cleanup()
+
+	player := newPlayer(server.db, "Steve")
+	session.AddPlayer(player)
+
+	// e.g. the same player connected from two devices
+	first, err := server.SubscribePlayerEvents(context.Background(), player, 0)
+	require.Nil(t, err)
+	second, err := server.SubscribePlayerEvents(context.Background(), player, 0)
+	require.Nil(t, err)
+
+	sent := []models.ServerEvent{models.NewServerEvent("FIRST"), models.NewServerEvent("SECOND")}
+	for _, event := range sent {
+		player.Send(event)
+	}
+
+	for _, events := range []<-chan models.ServerEvent{first, second} {
+		for _, expected := range sent {
+			select {
+			case event := <-events:
+				assert.Equal(t, expected, event)
+			case <-time.After(500 * time.Millisecond):
+				require.Fail(t, "Timeout", "Every subscription should receive every event")
+			}
+		}
+	}
+	assert.Len(t, player.ServerEvents, len(sent), "Subscriptions should not consume the player's channel")
+}
+
+func TestServer_SubscribePlayerEvents_CatchUp(t *testing.T) {
+	server, session, cleanup := newServerSession(t)
+	defer cleanup()
+
+	player := newPlayer(server.db, "Steve")
+	session.AddPlayer(player)
+	sent := []models.ServerEvent{
+		models.NewServerEvent("FIRST"),
+		models.NewServerEvent("SECOND"),
+		models.NewServerEvent("THIRD"),
+	}
+	for _, event := range sent {
+		player.Send(event)
+	}
+
+	events, err := server.SubscribePlayerEvents(context.Background(), player, sent[0].Sequence())
+	require.Nil(t, err)
+
+	for _, expected := range sent[1:] {
+		select {
+		case event := <-events:
+			assert.Equal(t, expected, event)
+		case <-time.After(500 * time.Millisecond):
+			require.Fail(t, "Timeout", "Did not receive expected server event before timeout")
+		}
+	}
+}
+
+func TestServer_SubscribePlayerEvents_TooFarBehind(t *testing.T) {
+	server, session, cleanup := newServerSession(t)
+	defer cleanup()
+
+	player := newPlayer(server.db, "Steve")
+	session.AddPlayer(player)
+	for i := 0; i <= models.PlayerHistorySize; i++ {
+		player.Send(models.NewServerEvent("EVENT"))
+	}
+
+	events, err := server.SubscribePlayerEvents(context.Background(), player, 0)
+	require.Nil(t, err)
+
+	select {
+	case event := <-events:
+		require.IsType(t, &models.SnapshotEvent{}, event)
+		assert.Equal(t, uint(models.PlayerHistorySize+1), event.Sequence())
+	case <-time.After(500 * time.Millisecond):
+		require.Fail(t, "Timeout", "Did not receive the snapshot before timeout")
+	}
+
+	latest := models.NewServerEvent("LATEST")
+	player.Send(latest)
+	select {
+	case event := <-events:
+		assert.Equal(t, latest, event)
+	case <-time.After(500 * time.Millisecond):
+		require.Fail(t, "Timeout", "Did not receive expected server event before timeout")
+	}
+}
+
+func TestServer_SubscribePlayerEvents_NoSession(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+
+	player := newPlayer(server.db, "Steve")
+	_, err := server.SubscribePlayerEvents(context.Background(), player, 0)
+	assert.ErrorContains(t, err, "has not joined a session")
+}
+
+func TestServer_ReapSession(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+	server.SessionGracePeriod = 50 * time.Millisecond
+
+	session, _ := server.NewSession(context.Background(), testEndingGameName, nil)
+	select {
+	case <-session.Done():
+	case <-time.After(500 * time.Millisecond):
+		require.Fail(t, "Timeout", "Session did not end")
+	}
+	assert.Equal(t, models.SessionFinished, session.CurrentStatus())
+	assert.Empty(t, server.ActiveSessions())
+
+	_, err := server.SessionForCode(session.Code)
+	assert.Nil(t, err, "Session was removed before its grace period elapsed")
+
+	assert.Eventually(t, func() bool {
+		_, err := server.SessionForCode(session.Code)
+		return err != nil
+	}, 500*time.Millisecond, 10*time.Millisecond, "Session was not removed after its grace period elapsed")
+}
+
+func TestServer_SpectateSession(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+
+	session, _ := server.NewSession(context.Background(), testJoinGameName, nil)
+	events, err := server.SpectateSession(context.Background(), session.Code, SessionAccess{})
+	require.Nil(t, err)
+
+	select {
+	case event := <-events:
+		require.IsType(t, &models.SnapshotEvent{}, event)
+		assert.Equal(t, "join", event.(*models.SnapshotEvent).State.Stage)
+	case <-time.After(500 * time.Millisecond):
+		require.Fail(t, "Timeout", "Did not receive the snapshot before timeout")
+	}
+
+	_, err = server.SpectateSession(context.Background(), "XXXX", SessionAccess{})
+	assert.ErrorContains(t, err, `could not find session with code "XXXX"`)
+}
+
+func TestServer_RestoreSessions(t *testing.T) {
+	dbpath := path.Join(t.TempDir(), "_tests.sqlite")
+	server, err := NewServer("sqlite", dbpath)
+	require.Nil(t, err)
+
+	session, _ := server.NewSession(context.Background(), testJoinGameName, nil)
+	credentials, err := server.JoinSession(context.Background(), session.Code, "Steve", "")
+	require.Nil(t, err)
+	// Wait for the session to be done with the join request, which includes saving its state
+	_, err = session.State(context.Background())
+	require.Nil(t, err)
+
+	restarted, err := NewServer("sqlite", dbpath)
+	require.Nil(t, err)
+	restarted.TokenSecret = server.TokenSecret
+
+	restored, err := restarted.SessionForCode(session.Code)
+	require.Nil(t, err)
+	assert.Equal(t, models.SessionLobby, restored.CurrentStatus())
+	assert.Equal(t, testJoinGameName, restored.GameKey)
+
+	player, err := restarted.Authenticate(credentials.Token)
+	require.Nil(t, err)
+	assert.Equal(t, "Steve", player.Name)
+	assert.Equal(t, restored, player.Session)
+
+	// The restored stage knows that the only spot in the game is taken
+	_, err = restarted.JoinSession(context.Background(), session.Code, "Annie", "")
+	assert.ErrorIs(t, err, ErrNotAcceptingPlayers)
+}
+
+func TestServer_RestoreSessions_UnknownGame(t *testing.T) {
+	dbpath := path.Join(t.TempDir(), "_tests.sqlite")
+	server, err := NewServer("sqlite", dbpath)
+	require.Nil(t, err)
+
+	session, _ := server.NewSession(context.Background(), testJoinGameName, nil)
+	server.db.Model(&models.Session{}).Where("id = ?", session.ID).Update("game_key", "UNKNOWN_GAME_NAME")
+
+	restarted, err := NewServer("sqlite", dbpath)
+	require.Nil(t, err)
+
+	_, err = restarted.SessionForCode(session.Code)
+	assert.ErrorContains(t, err, "could not find session")
+
+	var saved models.Session
+	restarted.db.First(&saved, session.ID)
+	assert.Equal(t, models.SessionAborted, saved.Status)
+}
+
 func TestBroadcast(t *testing.T) {
 	server, _ := newServer(t)
 	players := []*models.Player{
@@ -147,6 +660,28 @@ type testGame struct {
 	models.Game
 }
 
+type testJoinGame struct {
+	*models.Game
+}
+
+func newTestJoinStage() *join_stage.JoinGame {
+	return &join_stage.JoinGame{
+		MinPlayers: 1,
+		MaxPlayers: 1,
+		StartGame: func(roster *join_stage.Roster) models.StageRunner {
+			return &testStage{}
+		},
+	}
+}
+
+func (g *testJoinGame) RestoreStage(name string, state json.RawMessage, players []*models.Player) (models.StageRunner, error) {
+	stage := newTestJoinStage()
+	if err := stage.Restore(state, players); err != nil {
+		return nil, err
+	}
+	return stage, nil
+}
+
 type testStage struct{}
 
 func (s *testStage) Run(playerEvents <-chan models.PlayerEvent) models.StageRunner {
@@ -154,11 +689,17 @@ func (s *testStage) Run(playerEvents <-chan models.PlayerEvent) models.StageRunn
 		event := <-playerEvents
 		switch event := event.(type) {
 		case *echoEvent:
-			event.Sender().ServerEvents <- newEchoEchoEvent(event)
+			event.Sender().Send(newEchoEchoEvent(event))
 		}
 	}
 }
 
+type endingStage struct{}
+
+func (s *endingStage) Run(playerEvents <-chan models.PlayerEvent) models.StageRunner {
+	return nil
+}
+
 type echoEvent struct {
 	models.PlayerEvent
 
@@ -0,0 +1,108 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/sebmartin/collabd/models"
+)
+
+const ServerShutdownEventType models.EventType = "SERVER_SHUTDOWN"
+
+// Returned when a session is created or joined while the server is shutting down
+var ErrShuttingDown = errors.New("the server is shutting down")
+
+// Event sent to the participants of every active session when the server starts shutting down.
+// Sessions that are still running at the deadline, and those whose game has not started, are
+// suspended and resumed after a restart.
+type ServerShutdownEvent struct {
+	models.ServerEvent
+
+	// Nil when the server waits for every session to end
+	Deadline *time.Time
+}
+
+func NewServerShutdownEvent(deadline *time.Time) *ServerShutdownEvent {
+	return &ServerShutdownEvent{
+		ServerEvent: models.NewServerEvent(ServerShutdownEventType),
+		Deadline:    deadline,
+	}
+}
+
+// Stop the server gracefully. New sessions, joins and matchmaking are refused and the participants
+// of the active sessions are warned. The players waiting for a match are let go and the sessions
+// whose game has not started are suspended right away, since no one else can join them. The server
+// then waits for the other sessions to end until the context is done. The sessions still running
+// at that point are suspended so that they can be restored when the server restarts, in which case
+// the context's error is returned.
+//
+// The HTTP server should keep serving requests until this returns so that players can finish
+// their games, the event streams of the players and spectators are closed as their sessions end.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.sessionsMu.Lock()
+	s.shuttingDown = true
+	s.sessionsMu.Unlock()
+
+	var deadline *time.Time
+	if d, ok := ctx.Deadline(); ok {
+		deadline = &d
+	}
+	s.Matchmaker.failAll(ErrShuttingDown)
+
+	var running []*models.Session
+	for _, session := range s.ActiveSessions() {
+		session.Notify(NewServerShutdownEvent(deadline))
+		if session.CurrentStatus() == models.SessionLobby {
+			suspend(session)
+		} else {
+			running = append(running, session)
+		}
+	}
+
+	if waitForSessions(ctx, running) == nil {
+		return nil
+	}
+
+	for _, session := range s.ActiveSessions() {
+		suspend(session)
+	}
+	return ctx.Err()
+}
+
+// Suspend a session and wait for its event loop to stop, sessions that have already ended are
+// left as is
+func suspend(session *models.Session) {
+	if err := session.Suspend(); err != nil {
+		return
+	}
+	<-session.Done()
+	log.Printf(`Suspended session "%s"`, session.Code)
+}
+
+func waitForSessions(ctx context.Context, sessions []*models.Session) error {
+	for _, session := range sessions {
+		select {
+		case <-session.Done():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Release the server's database, once it has been shut down
+func (s *Server) Close() error {
+	db, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+func (s *Server) isShuttingDown() bool {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+	return s.shuttingDown
+}
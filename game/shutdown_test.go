@@ -0,0 +1,94 @@
+package game
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/sebmartin/collabd/game/join_stage"
+	"github.com/sebmartin/collabd/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Shutdown(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+
+	session, _ := server.NewSession(context.Background(), testEndingGameName, nil)
+	<-session.Done()
+
+	assert.Nil(t, server.Shutdown(context.Background()))
+	_, err := server.NewSession(context.Background(), testGameName, nil)
+	assert.ErrorIs(t, err, ErrShuttingDown)
+	_, err = server.JoinSession(context.Background(), session.Code, "Steve", "")
+	assert.ErrorIs(t, err, ErrShuttingDown)
+}
+
+func TestServer_Shutdown_Suspend(t *testing.T) {
+	dbpath := path.Join(t.TempDir(), "_tests.sqlite")
+	server, err := NewServer("sqlite", dbpath)
+	require.Nil(t, err)
+
+	lobby, _ := server.NewSession(context.Background(), testJoinGameName, nil)
+	credentials, err := server.JoinSession(context.Background(), lobby.Code, "Steve", "")
+	require.Nil(t, err)
+	events, err := server.SubscribePlayerEvents(context.Background(), credentials.Player, 0)
+	require.Nil(t, err)
+
+	running, _ := server.NewSession(context.Background(), testJoinGameName, nil)
+	host, err := server.JoinSession(context.Background(), running.Code, "Annie", "")
+	require.Nil(t, err)
+	running.HandlePlayerEvent(join_stage.NewStartEvent(context.Background(), host.Player))
+	require.Eventually(t, func() bool {
+		return running.CurrentStatus() == models.SessionRunning
+	}, time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	suspendedLobby := make(chan time.Time, 1)
+	go func() {
+		<-lobby.Done()
+		suspendedLobby <- time.Now()
+	}()
+	assert.ErrorIs(t, server.Shutdown(ctx), context.DeadlineExceeded)
+	deadline, _ := ctx.Deadline()
+	assert.True(t, (<-suspendedLobby).Before(deadline), "Sessions in their lobby should be suspended right away")
+	assert.Equal(t, models.SessionLobby, lobby.CurrentStatus(), "Suspended sessions should keep their status")
+	assert.Equal(t, models.SessionRunning, running.CurrentStatus(), "Suspended sessions should keep their status")
+
+	var received []models.ServerEvent
+	for event := range events {
+		received = append(received, event)
+	}
+	require.NotEmpty(t, received, "The player's events should end with the session")
+	require.IsType(t, &ServerShutdownEvent{}, received[len(received)-1])
+	shutdown := received[len(received)-1].(*ServerShutdownEvent)
+	assert.Equal(t, &deadline, shutdown.Deadline)
+
+	require.Nil(t, server.Close())
+	restarted, err := NewServer("sqlite", dbpath)
+	require.Nil(t, err)
+	restored, err := restarted.SessionForCode(lobby.Code)
+	require.Nil(t, err)
+	assert.Len(t, restored.Players, 1)
+}
+
+func TestServer_Shutdown_Matchmaking(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+
+	updates, err := server.Matchmaker.Queue(context.Background(), testMatchGameName, "Annie", QueueOptions{})
+	require.Nil(t, err)
+	<-updates // Ticket
+
+	require.Nil(t, server.Shutdown(context.Background()))
+	select {
+	case update := <-updates:
+		assert.ErrorIs(t, update.Err, ErrShuttingDown)
+	case <-time.After(time.Second):
+		require.Fail(t, "Timeout", "Annie's ticket was not failed")
+	}
+	assert.Zero(t, server.Matchmaker.Stats(testMatchGameName).Size)
+}
@@ -0,0 +1,85 @@
+package game
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sebmartin/collabd/models"
+)
+
+// A player along with the token that the player must present to act in its session
+type PlayerCredentials struct {
+	Player *models.Player
+	Token  string
+}
+
+type tokenClaims struct {
+	SessionID uint `json:"s"`
+	PlayerID  uint `json:"p"`
+	// Unix time after which the token is no longer accepted
+	ExpiresAt int64 `json:"e"`
+}
+
+var (
+	errInvalidToken = fmt.Errorf("invalid player token")
+	errExpiredToken = fmt.Errorf("the player token has expired, join the session again to get a new one")
+)
+
+// Issue the token that identifies a player of a live session. Tokens are opaque to clients, they
+// hold the IDs of the player and its session signed with the server's token secret, and expire
+// after the server's TokenTTL.
+func (s *Server) IssueToken(player *models.Player) (string, error) {
+	if player.Session == nil {
+		return "", fmt.Errorf("cannot issue a token to player %s who has not joined a session", player.Name)
+	}
+
+	claims, err := json.Marshal(tokenClaims{
+		SessionID: player.Session.ID,
+		PlayerID:  player.ID,
+		ExpiresAt: s.Clock.Now().Add(s.TokenTTL).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(claims)
+	return encoded + "." + s.sign(encoded), nil
+}
+
+// Find the player identified by a token issued by IssueToken.
+func (s *Server) Authenticate(token string) (*models.Player, error) {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(s.sign(encoded))) {
+		return nil, errInvalidToken
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(decoded, &claims); err != nil {
+		return nil, errInvalidToken
+	}
+	// Tokens issued before they had an expiry are refused as well
+	if !s.Clock.Now().Before(time.Unix(claims.ExpiresAt, 0)) {
+		return nil, errExpiredToken
+	}
+
+	session, err := s.SessionForID(claims.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	return session.PlayerForID(claims.PlayerID)
+}
+
+func (s *Server) sign(encoded string) string {
+	mac := hmac.New(sha256.New, s.TokenSecret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,114 @@
+package game
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/sebmartin/collabd/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Authenticate(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+
+	session, _ := server.NewSession(context.Background(), testJoinGameName, nil)
+	credentials, err := server.JoinSession(context.Background(), session.Code, "Steve", "")
+	require.Nil(t, err)
+
+	player, err := server.Authenticate(credentials.Token)
+	require.Nil(t, err)
+	assert.Equal(t, credentials.Player, player)
+}
+
+func TestServer_Authenticate_InvalidToken(t *testing.T) {
+	server, session, cleanup := newServerSession(t)
+	defer cleanup()
+
+	player := newPlayer(server.db, "Steve")
+	session.AddPlayer(player)
+	token, err := server.IssueToken(player)
+	require.Nil(t, err)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "empty", token: ""},
+		{name: "no signature", token: "abc"},
+		{name: "tampered claims", token: "x" + token},
+		{name: "tampered signature", token: token + "x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := server.Authenticate(tt.token)
+			assert.ErrorIs(t, err, errInvalidToken)
+		})
+	}
+}
+
+func TestServer_Authenticate_Expired(t *testing.T) {
+	server, session, cleanup := newServerSession(t)
+	defer cleanup()
+	clock := models.NewFakeClock(time.Now())
+	server.Clock = clock
+	server.TokenTTL = time.Hour
+
+	player := newPlayer(server.db, "Steve")
+	session.AddPlayer(player)
+	token, err := server.IssueToken(player)
+	require.Nil(t, err)
+
+	clock.Advance(time.Hour - time.Second)
+	_, err = server.Authenticate(token)
+	require.Nil(t, err)
+
+	clock.Advance(time.Second)
+	_, err = server.Authenticate(token)
+	assert.ErrorIs(t, err, errExpiredToken)
+
+	// Tokens issued before tokens had an expiry are refused
+	claims, _ := json.Marshal(map[string]uint{"s": session.ID, "p": player.ID})
+	encoded := base64.RawURLEncoding.EncodeToString(claims)
+	_, err = server.Authenticate(encoded + "." + server.sign(encoded))
+	assert.ErrorIs(t, err, errExpiredToken)
+}
+
+func TestServer_Authenticate_OtherServer(t *testing.T) {
+	server, session, cleanup := newServerSession(t)
+	defer cleanup()
+	other, otherCleanup := newServer(t)
+	defer otherCleanup()
+
+	player := newPlayer(server.db, "Steve")
+	session.AddPlayer(player)
+	token, _ := server.IssueToken(player)
+
+	_, err := other.Authenticate(token)
+	assert.ErrorIs(t, err, errInvalidToken)
+}
+
+func TestServer_Authenticate_UnknownPlayer(t *testing.T) {
+	server, session, cleanup := newServerSession(t)
+	defer cleanup()
+
+	player := newPlayer(server.db, "Steve")
+	player.Session = session
+	token, _ := server.IssueToken(player)
+
+	_, err := server.Authenticate(token)
+	assert.ErrorContains(t, err, "could not find player with id")
+}
+
+func TestServer_IssueToken_NoSession(t *testing.T) {
+	server, cleanup := newServer(t)
+	defer cleanup()
+
+	_, err := server.IssueToken(newPlayer(server.db, "Steve"))
+	assert.ErrorContains(t, err, "has not joined a session")
+}
@@ -1,6 +1,10 @@
 package connect4
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
 
 type Piece uint8
 
@@ -10,6 +14,40 @@ const (
 	Black
 )
 
+var pieceNames = map[Piece]string{
+	Unclaimed: "UNCLAIMED",
+	Red:       "RED",
+	Black:     "BLACK",
+}
+
+// Pieces are presented to GraphQL clients as the Connect4Piece enum
+func (p Piece) MarshalGQL(w io.Writer) {
+	io.WriteString(w, strconv.Quote(pieceNames[p]))
+}
+
+// Pieces are presented to clients by name, e.g. "RED"
+func (p Piece) MarshalText() ([]byte, error) {
+	return []byte(pieceNames[p]), nil
+}
+
+func (p *Piece) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("pieces must be strings")
+	}
+	return p.UnmarshalText([]byte(str))
+}
+
+func (p *Piece) UnmarshalText(text []byte) error {
+	for piece, name := range pieceNames {
+		if name == string(text) {
+			*p = piece
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not a valid Connect4Piece", text)
+}
+
 const (
 	MaxColumns uint = 7
 	MaxRows    uint = 6
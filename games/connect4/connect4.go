@@ -2,30 +2,116 @@ package connect4
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"time"
 
 	"github.com/sebmartin/collabd/game"
 	"github.com/sebmartin/collabd/game/join_stage"
 	"github.com/sebmartin/collabd/models"
 )
 
+const (
+	FirstPlayerOption = "firstPlayer"
+
+	// The first player to join plays first
+	FirstPlayerJoinOrder = "joinOrder"
+	// The player who plays first is picked at random
+	FirstPlayerRandom = "random"
+
+	// Number of seconds a player has to drop a piece before their turn is skipped
+	TurnTimeLimitOption = "turnTimeLimit"
+)
+
+var Info = game.GameInfo{
+	Key:               "Connect4",
+	Name:              "Connect 4",
+	Description:       "Take turns dropping pieces in a grid and be the first to line up four of them.",
+	MinPlayers:        2,
+	MaxPlayers:        2,
+	EstimatedDuration: 10 * time.Minute,
+	Rules: "Players take turns dropping one of their pieces in one of the seven columns of the board. " +
+		"The piece falls to the lowest free row of that column. The first player to line up four of " +
+		"their pieces horizontally, vertically or diagonally wins the game.",
+	Options: []game.OptionSpec{
+		game.EnumOption(FirstPlayerOption, "Which player drops the first piece",
+			FirstPlayerJoinOrder, FirstPlayerJoinOrder, FirstPlayerRandom,
+		),
+		game.IntOption(TurnTimeLimitOption, "Seconds a player has to drop a piece before their turn is skipped, 0 for no limit",
+			0, 0, 600,
+		),
+	},
+}
+
+type connect4Game struct {
+	*models.Game
+	options game.Options
+}
+
+func newGame(options game.Options) *connect4Game {
+	return &connect4Game{
+		Game:    models.NewGame(Info.Name, newInitialStage(options)),
+		options: options,
+	}
+}
+
+// Restore one of the game's stages from its snapshot, e.g. after the server restarts
+func (g *connect4Game) RestoreStage(name string, state json.RawMessage, players []*models.Player) (models.StageRunner, error) {
+	switch name {
+	case join_stage.StageName:
+		stage := newInitialStage(g.options)
+		if err := stage.Restore(state, players); err != nil {
+			return nil, err
+		}
+		return stage, nil
+	case "connect4":
+		stage, err := restoreMainStage(state, players, turnTimeLimit(g.options))
+		if err != nil {
+			return nil, err
+		}
+		return stage, nil
+	default:
+		return nil, fmt.Errorf("unknown stage: %s", name)
+	}
+}
+
 func Register() {
-	game.Register("Connect4", func(ctx context.Context) (models.GameDescriber, error) {
-		return models.NewGame(
-			"Connect 4",
-			newInitialStage(),
-		), nil
+	game.Register(Info, func(ctx context.Context, options game.Options) (models.GameDescriber, error) {
+		return newGame(options), nil
 	})
+	game.RegisterEvent(DropPieceEventType, decodeDropPieceEvent)
+	// Their GraphQL types are declared in connect4.graphqls
+	for _, eventType := range []models.EventType{PlayerTurnEventType, DidDropPieceEventType, DidWinEventType} {
+		game.RegisterTypedServerEvent(eventType)
+	}
 }
 
-func newInitialStage() models.StageRunner {
+func newInitialStage(options game.Options) *join_stage.JoinGame {
 	return &join_stage.JoinGame{
-		MinPlayers: 2,
-		MaxPlayers: 2,
-		StartGame:  newMainStage,
+		MinPlayers: Info.MinPlayers,
+		MaxPlayers: Info.MaxPlayers,
+		// Both seats are taken, there is no one left to wait for
+		AutoStartWhenFull: true,
+		Seating:           seating(options),
+		StartGame: func(roster *join_stage.Roster) models.StageRunner {
+			return newMainStage(roster.Players(), turnTimeLimit(options))
+		},
 	}
 }
 
-func newMainStage(players []*models.Player) models.StageRunner {
+// The player in the first seat plays first
+func seating(options game.Options) join_stage.SeatingPolicy {
+	if options.String(FirstPlayerOption) == FirstPlayerRandom {
+		return join_stage.SeatingRandom
+	}
+	return join_stage.SeatingJoinOrder
+}
+
+func turnTimeLimit(options game.Options) time.Duration {
+	return time.Duration(options.Int(TurnTimeLimitOption)) * time.Second
+}
+
+func newMainStage(players []*models.Player, turnTimeLimit time.Duration) models.StageRunner {
 	if len(players) != 2 {
 		panic("Connect 4 requires exactly two players")
 	}
@@ -34,7 +120,9 @@ func newMainStage(players []*models.Player) models.StageRunner {
 		players: [2]*models.Player{
 			players[0], players[1],
 		},
-		activePlayer: players[0], // TODO Randomize?
-		board:        Board{},
+		// The turn order is decided by the join stage's seating policy
+		activePlayer:  players[0],
+		board:         Board{},
+		turnTimeLimit: turnTimeLimit,
 	}
 }
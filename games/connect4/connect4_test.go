@@ -0,0 +1,33 @@
+package connect4
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/sebmartin/collabd/game"
+	"github.com/sebmartin/collabd/game/join_stage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	Register()
+}
+
+func Test_connect4_Options(t *testing.T) {
+	describer, err := game.NewGame(Info.Key, context.Background(), json.RawMessage(`{"firstPlayer": "random", "turnTimeLimit": 30}`))
+	require.Nil(t, err)
+	require.IsType(t, &connect4Game{}, describer)
+	created := describer.(*connect4Game)
+	assert.Equal(t, 30*time.Second, turnTimeLimit(created.options))
+
+	stage := created.InitialStage()
+	require.IsType(t, &join_stage.JoinGame{}, stage)
+	assert.Equal(t, join_stage.SeatingRandom, stage.(*join_stage.JoinGame).Seating)
+
+	describer, err = game.NewGame(Info.Key, context.Background(), nil)
+	require.Nil(t, err)
+	assert.Equal(t, join_stage.SeatingJoinOrder, describer.InitialStage().(*join_stage.JoinGame).Seating, "The first player to join should play first by default")
+}
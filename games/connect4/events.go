@@ -2,7 +2,9 @@ package connect4
 
 import (
 	"context"
+	"encoding/json"
 
+	"github.com/sebmartin/collabd/game"
 	"github.com/sebmartin/collabd/models"
 )
 
@@ -16,13 +18,13 @@ const (
 type PlayerTurnEvent struct {
 	models.ServerEvent
 
-	activePlayer *models.Player
+	ActivePlayer *models.Player
 }
 
 func NewPlayerTurnEvent(activePlayer *models.Player) *PlayerTurnEvent {
 	return &PlayerTurnEvent{
 		ServerEvent:  models.NewServerEvent(PlayerTurnEventType),
-		activePlayer: activePlayer,
+		ActivePlayer: activePlayer,
 	}
 }
 
@@ -39,6 +41,20 @@ func NewDropPieceEvent(ctx context.Context, sender *models.Player, slot uint) *D
 	}
 }
 
+// Decodes a DropPieceEvent sent as an action, e.g. `{"slot": 3}`
+func decodeDropPieceEvent(ctx context.Context, sender *models.Player, payload json.RawMessage) (models.PlayerEvent, error) {
+	var args struct {
+		Slot *uint `json:"slot"`
+	}
+	if err := game.DecodePayload(payload, &args); err != nil {
+		return nil, err
+	}
+	if args.Slot == nil {
+		return nil, game.NewValidationError(game.InvalidPayloadError, "invalid payload: slot is required")
+	}
+	return NewDropPieceEvent(ctx, sender, *args.Slot), nil
+}
+
 type DidDropPieceEvent struct {
 	models.ServerEvent
 
@@ -70,3 +86,12 @@ func NewDidWinGame(winner *models.Player, board *Board) *DidWinGame {
 		Board:       *board,
 	}
 }
+
+// The rows of the winning board from the top, GraphQL lists cannot be bound to arrays
+func (e *DidWinGame) Rows() [][]Piece {
+	rows := make([][]Piece, len(e.Board))
+	for i := range e.Board {
+		rows[i] = e.Board[i][:]
+	}
+	return rows
+}
@@ -0,0 +1,53 @@
+package connect4
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/sebmartin/collabd/game"
+	"github.com/sebmartin/collabd/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_decodeDropPieceEvent(t *testing.T) {
+	player := &models.Player{Name: "Alice"}
+
+	event, err := decodeDropPieceEvent(context.Background(), player, json.RawMessage(`{"slot": 3}`))
+	require.Nil(t, err)
+	require.IsType(t, &DropPieceEvent{}, event)
+	assert.Equal(t, uint(3), event.(*DropPieceEvent).Slot)
+	assert.Equal(t, player, event.Sender())
+}
+
+func Test_decodeDropPieceEvent_InvalidPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		message string
+	}{
+		{name: "missing slot", payload: `{}`, message: "slot is required"},
+		{name: "negative slot", payload: `{"slot": -1}`, message: "invalid payload"},
+		{name: "unknown field", payload: `{"slot": 1, "column": 2}`, message: `unknown field "column"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeDropPieceEvent(context.Background(), &models.Player{}, json.RawMessage(tt.payload))
+			require.IsType(t, &game.ValidationError{}, err)
+			assert.Equal(t, game.InvalidPayloadError, err.(*game.ValidationError).Code)
+			assert.ErrorContains(t, err, tt.message)
+		})
+	}
+}
+
+func TestDidWinGame_Rows(t *testing.T) {
+	board := &Board{}
+	board[MaxRows-1][3] = Red
+	event := NewDidWinGame(&models.Player{Name: "Alice"}, board)
+
+	rows := event.Rows()
+	require.Len(t, rows, int(MaxRows))
+	assert.Equal(t, []Piece{Unclaimed, Unclaimed, Unclaimed, Red, Unclaimed, Unclaimed, Unclaimed}, rows[MaxRows-1])
+	assert.Equal(t, make([]Piece, MaxColumns), rows[0])
+}
@@ -1,43 +1,64 @@
 package connect4
 
 import (
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/sebmartin/collabd/game"
 	"github.com/sebmartin/collabd/models"
 )
 
+// Name of the timer that skips the active player's turn when the turn time limit is reached
+const turnTimer = "turn"
+
 type mainStage struct {
 	players      [2]*models.Player
 	activePlayer *models.Player
 	board        Board
+
+	// No limit when zero
+	turnTimeLimit time.Duration
+	timers        models.Timers
+	// Whether the players were told whose turn it is, which is the case when the stage was restored
+	turnAnnounced bool
+	// Time the active player had left when the stage was restored
+	turnTimeLeft time.Duration
+}
+
+func (s *mainStage) UseTimers(timers models.Timers) {
+	s.timers = timers
 }
 
 func (s *mainStage) Run(playerEvents <-chan models.PlayerEvent) models.StageRunner {
-	game.Broadcast(s.players[:], NewPlayerTurnEvent(s.activePlayer))
+	if !s.turnAnnounced {
+		s.announceTurn()
+	} else if s.turnTimeLeft > 0 && s.timers != nil {
+		// The turn resumes where it was when the stage was restored
+		s.timers.Schedule(turnTimer, s.turnTimeLeft)
+	}
 
-	for {
-		event := <-playerEvents
+	for event := range playerEvents {
 		switch event := event.(type) {
 		case *DropPieceEvent:
 			player := event.Sender()
 			piece, err := s.playerPiece(player)
 			if err != nil {
-				player.ServerEvents <- models.NewErrorEvent(err)
+				player.Send(models.NewErrorEvent(err))
 				continue
 			}
 
 			if player.ID != s.activePlayer.ID {
-				player.ServerEvents <- models.NewErrorEvent(
+				player.Send(models.NewErrorEvent(
 					fmt.Errorf("player attempted to drop piece when not their turn: %s", player.Name),
-				)
+				))
 				continue
 			}
 
 			slot := event.Slot
 			row, err := s.board.DropPiece(piece, slot)
 			if err != nil {
-				player.ServerEvents <- models.NewErrorEvent(err)
+				player.Send(models.NewErrorEvent(err))
 				continue
 			}
 
@@ -56,15 +77,95 @@ func (s *mainStage) Run(playerEvents <-chan models.PlayerEvent) models.StageRunn
 			// Next player's turn
 			otherPlayer, err := s.otherPlayer(player)
 			if err != nil {
-				player.ServerEvents <- models.NewErrorEvent(err)
+				player.Send(models.NewErrorEvent(err))
 				continue
 			}
 			s.activePlayer = otherPlayer // TODO: make thread safe?
-			game.Broadcast(s.players[:], NewPlayerTurnEvent(
-				otherPlayer,
-			))
+			s.announceTurn()
+		case *models.TimerEvent:
+			if event.Name != turnTimer {
+				continue
+			}
+			// The active player took too long, the other player's turn begins
+			s.activePlayer, _ = s.otherPlayer(s.activePlayer)
+			s.announceTurn()
+		}
+	}
+	// The session was aborted
+	return nil
+}
+
+// Let the players know whose turn it is and start the turn's timer
+func (s *mainStage) announceTurn() {
+	s.turnAnnounced = true
+	game.Broadcast(s.players[:], NewPlayerTurnEvent(s.activePlayer))
+	if s.turnTimeLimit > 0 && s.timers != nil {
+		s.timers.Schedule(turnTimer, s.turnTimeLimit)
+	}
+}
+
+type mainStageSnapshot struct {
+	Players      [2]uint `json:"players"`
+	ActivePlayer uint    `json:"activePlayer"`
+	Board        Board   `json:"board"`
+	// Milliseconds left in the active player's turn, when turns have a time limit
+	TurnTimeLeft int64 `json:"turnTimeLeft,omitempty"`
+}
+
+func (s *mainStage) StageName() string {
+	return "connect4"
+}
+
+func (s *mainStage) Snapshot() interface{} {
+	snapshot := mainStageSnapshot{
+		Players:      [2]uint{s.players[0].ID, s.players[1].ID},
+		ActivePlayer: s.activePlayer.ID,
+		Board:        s.board,
+	}
+	if s.timers != nil {
+		if remaining, ok := s.timers.Remaining(turnTimer); ok {
+			// Rounded up so that a turn that is about to end still has a limit once restored
+			snapshot.TurnTimeLeft = int64((remaining + time.Millisecond - 1) / time.Millisecond)
+			if snapshot.TurnTimeLeft == 0 {
+				snapshot.TurnTimeLeft = 1
+			}
 		}
 	}
+	return snapshot
+}
+
+// Restore the stage from a snapshot taken by Snapshot(). The players were already told whose
+// turn it is, it is not announced again and the turn's timer resumes with the time that was left.
+func restoreMainStage(state json.RawMessage, players []*models.Player, turnTimeLimit time.Duration) (*mainStage, error) {
+	var snapshot mainStageSnapshot
+	if err := json.Unmarshal(state, &snapshot); err != nil {
+		return nil, err
+	}
+
+	stage := &mainStage{
+		board:         snapshot.Board,
+		turnTimeLimit: turnTimeLimit,
+		turnAnnounced: true,
+		turnTimeLeft:  time.Duration(snapshot.TurnTimeLeft) * time.Millisecond,
+	}
+	if stage.turnTimeLeft == 0 {
+		// Snapshots saved before the time left was kept get a whole turn
+		stage.turnTimeLeft = turnTimeLimit
+	}
+	for i, id := range snapshot.Players {
+		player, err := models.FindPlayer(players, id)
+		if err != nil {
+			return nil, err
+		}
+		stage.players[i] = player
+		if id == snapshot.ActivePlayer {
+			stage.activePlayer = player
+		}
+	}
+	if stage.activePlayer == nil {
+		return nil, fmt.Errorf("active player %d is not playing the game", snapshot.ActivePlayer)
+	}
+	return stage, nil
 }
 
 func (s *mainStage) playerPiece(player *models.Player) (Piece, error) {
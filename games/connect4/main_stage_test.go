@@ -2,6 +2,7 @@ package connect4
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"testing"
 	"time"
@@ -16,7 +17,7 @@ func newTestMainStage(db *gorm.DB) (*mainStage, chan models.PlayerEvent) {
 	player1, player2 := newTestPlayer(db, "Alice"), newTestPlayer(db, "Benny")
 
 	events := make(chan models.PlayerEvent, 100)
-	stage := newMainStage([]*models.Player{player1, player2})
+	stage := newMainStage([]*models.Player{player1, player2}, 0)
 	go stage.Run(events)
 
 	return stage.(*mainStage), events
@@ -273,3 +274,132 @@ func Test_mainStage_playerPiece_unknownPlayer(t *testing.T) {
 	_, err := stage.playerPiece(imposter)
 	assert.ErrorContains(t, err, "unknown player: Imposter")
 }
+
+func Test_mainStage_Snapshot(t *testing.T) {
+	db, cleanup := models.ConnectWithTestDB()
+	defer cleanup()
+
+	stage, _ := newTestMainStage(db)
+	player1 := stage.players[0]
+	player2 := stage.players[1]
+	stage.board.DropPiece(Red, 3)
+
+	encoded, err := json.Marshal(stage.Snapshot())
+	require.Nil(t, err)
+	assert.Equal(t, "connect4", stage.StageName())
+	assert.JSONEq(t, fmt.Sprintf(`{
+		"players": [%d, %d],
+		"activePlayer": %d,
+		"board": [
+			["UNCLAIMED", "UNCLAIMED", "UNCLAIMED", "UNCLAIMED", "UNCLAIMED", "UNCLAIMED", "UNCLAIMED"],
+			["UNCLAIMED", "UNCLAIMED", "UNCLAIMED", "UNCLAIMED", "UNCLAIMED", "UNCLAIMED", "UNCLAIMED"],
+			["UNCLAIMED", "UNCLAIMED", "UNCLAIMED", "UNCLAIMED", "UNCLAIMED", "UNCLAIMED", "UNCLAIMED"],
+			["UNCLAIMED", "UNCLAIMED", "UNCLAIMED", "UNCLAIMED", "UNCLAIMED", "UNCLAIMED", "UNCLAIMED"],
+			["UNCLAIMED", "UNCLAIMED", "UNCLAIMED", "UNCLAIMED", "UNCLAIMED", "UNCLAIMED", "UNCLAIMED"],
+			["UNCLAIMED", "UNCLAIMED", "UNCLAIMED", "RED", "UNCLAIMED", "UNCLAIMED", "UNCLAIMED"]
+		]
+	}`, player1.ID, player2.ID, player1.ID), string(encoded))
+}
+
+func Test_connect4Game_RestoreStage(t *testing.T) {
+	db, cleanup := models.ConnectWithTestDB()
+	defer cleanup()
+
+	player1, player2 := newTestPlayer(db, "Alice"), newTestPlayer(db, "Benny")
+	stage := newMainStage([]*models.Player{player1, player2}, 0).(*mainStage)
+	stage.board.DropPiece(Red, 3)
+	stage.activePlayer = player2
+	encoded, _ := json.Marshal(stage.Snapshot())
+
+	restored, err := newGame(nil).RestoreStage(stage.StageName(), encoded, []*models.Player{player2, player1})
+	require.Nil(t, err)
+	// The turn was announced before the snapshot was taken
+	stage.turnAnnounced = true
+	assert.Equal(t, stage, restored)
+}
+
+func Test_mainStage_Restored(t *testing.T) {
+	db, cleanup := models.ConnectWithTestDB()
+	defer cleanup()
+
+	player1, player2 := newTestPlayer(db, "Alice"), newTestPlayer(db, "Benny")
+	stage := newMainStage([]*models.Player{player1, player2}, 30*time.Second).(*mainStage)
+	stage.activePlayer = player2
+	timers := models.NewFakeTimers()
+	timers.Schedule(turnTimer, 12*time.Second)
+	stage.UseTimers(timers)
+	encoded, _ := json.Marshal(stage.Snapshot())
+	assert.Contains(t, string(encoded), `"turnTimeLeft":12000`)
+
+	restored, err := restoreMainStage(encoded, []*models.Player{player1, player2}, 30*time.Second)
+	require.Nil(t, err)
+	timers = models.NewFakeTimers()
+	restored.UseTimers(timers)
+
+	events := make(chan models.PlayerEvent)
+	go restored.Run(events)
+	// Synchronize with the stage before reading its timers, the turn resumes with the time it had left
+	events <- models.NewPlayerEvent(context.Background(), "SYNC", nil)
+	assert.Equal(t, map[string]time.Duration{turnTimer: 12 * time.Second}, timers.Scheduled())
+	playPiece(restored, events, player2, 3)
+
+	// The turn is only announced once it changes
+	assertServerEvents(t, player1, []models.ServerEvent{
+		NewDidDropPieceEvent(Black, 3, 5),
+		NewPlayerTurnEvent(player1),
+	})
+	events <- models.NewPlayerEvent(context.Background(), "SYNC", nil)
+	assert.Equal(t, map[string]time.Duration{turnTimer: 30 * time.Second}, timers.Scheduled())
+	close(events)
+}
+
+func Test_mainStage_Restored_WithoutTimeLeft(t *testing.T) {
+	db, cleanup := models.ConnectWithTestDB()
+	defer cleanup()
+
+	// Snapshots saved before the time left was kept
+	player1, player2 := newTestPlayer(db, "Alice"), newTestPlayer(db, "Benny")
+	encoded := fmt.Sprintf(`{"players": [%d, %d], "activePlayer": %d}`, player1.ID, player2.ID, player1.ID)
+	restored, err := restoreMainStage(json.RawMessage(encoded), []*models.Player{player1, player2}, 30*time.Second)
+	require.Nil(t, err)
+	assert.Equal(t, 30*time.Second, restored.turnTimeLeft)
+}
+
+func Test_connect4Game_RestoreStage_UnknownPlayer(t *testing.T) {
+	db, cleanup := models.ConnectWithTestDB()
+	defer cleanup()
+
+	player1, player2 := newTestPlayer(db, "Alice"), newTestPlayer(db, "Benny")
+	stage := newMainStage([]*models.Player{player1, player2}, 0).(*mainStage)
+	encoded, _ := json.Marshal(stage.Snapshot())
+
+	_, err := newGame(nil).RestoreStage(stage.StageName(), encoded, []*models.Player{player1})
+	assert.ErrorContains(t, err, fmt.Sprintf("could not find player with id %d", player2.ID))
+}
+
+func Test_mainStage_TurnTimeLimit(t *testing.T) {
+	db, cleanup := models.ConnectWithTestDB()
+	defer cleanup()
+
+	player1, player2 := newTestPlayer(db, "Alice"), newTestPlayer(db, "Benny")
+	stage := newMainStage([]*models.Player{player1, player2}, 30*time.Second).(*mainStage)
+	timers := models.NewFakeTimers()
+	stage.UseTimers(timers)
+
+	events := make(chan models.PlayerEvent)
+	go stage.Run(events)
+	assertServerEvents(t, player1, []models.ServerEvent{NewPlayerTurnEvent(player1)})
+
+	// player1 runs out of time
+	events <- timers.Fire(turnTimer)
+	assertServerEvents(t, player1, []models.ServerEvent{NewPlayerTurnEvent(player2)})
+	assertServerEvents(t, player2, []models.ServerEvent{
+		NewPlayerTurnEvent(player1),
+		NewPlayerTurnEvent(player2),
+	})
+
+	// Synchronize with the stage before reading its timers
+	events <- models.NewPlayerEvent(context.Background(), "SYNC", nil)
+	assert.Equal(t, map[string]time.Duration{turnTimer: 30 * time.Second}, timers.Scheduled())
+	assert.Equal(t, player2, stage.activePlayer)
+}
@@ -0,0 +1,82 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/sebmartin/collabd/game"
+	"github.com/sebmartin/collabd/models"
+)
+
+type contextKey string
+
+const playerTokenKey = contextKey("playerToken")
+
+// Middleware that makes the player token found in the Authorization header of a request available
+// to the resolvers, e.g. `Authorization: Bearer <token>`
+func AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := bearerToken(r.Header.Get("Authorization")); token != "" {
+			r = r.WithContext(context.WithValue(r.Context(), playerTokenKey, token))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Middleware that identifies the client of a request by its address, see game.WithClient()
+func ClientMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(game.WithClient(r.Context(), clientAddress(r)))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The host of the request's remote address, a client connecting from several ports is the same
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Websocket init function that makes the player token found in the connection's init payload
+// available to the resolvers, e.g. `{"Authorization": "Bearer <token>"}`
+func WebsocketInit(ctx context.Context, payload transport.InitPayload) (context.Context, error) {
+	if token := bearerToken(payload.Authorization()); token != "" {
+		ctx = context.WithValue(ctx, playerTokenKey, token)
+	}
+	return ctx, nil
+}
+
+func bearerToken(authorization string) string {
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	return strings.TrimSpace(token)
+}
+
+// The player identified by the token of the current request
+func (r *Resolver) authenticatedPlayer(ctx context.Context) (*models.Player, error) {
+	token, _ := ctx.Value(playerTokenKey).(string)
+	if token == "" {
+		return nil, fmt.Errorf("a player token is required, join a session to get one")
+	}
+	return r.GameServer.Authenticate(token)
+}
+
+// What the current request gives access to private sessions with, the authenticated player if
+// there is one along with the arguments of the request
+func (r *Resolver) sessionAccess(ctx context.Context, passcode *string, invite *string) (game.SessionAccess, error) {
+	access := game.SessionAccess{Passcode: valueOrEmpty(passcode), Invite: valueOrEmpty(invite)}
+	if token, _ := ctx.Value(playerTokenKey).(string); token != "" {
+		player, err := r.GameServer.Authenticate(token)
+		if err != nil {
+			return access, err
+		}
+		access.Player = player
+	}
+	return access, nil
+}
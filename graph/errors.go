@@ -0,0 +1,32 @@
+package graph
+
+import (
+	"context"
+	"errors"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// Errors that carry machine readable details for clients, like game.ValidationError
+type extendedError interface {
+	error
+	Extensions() map[string]interface{}
+}
+
+// Presents resolver errors to clients, including the extensions of errors that provide them. They
+// are merged with the extensions the error already had, e.g. those set by gqlgen.
+func ErrorPresenter(ctx context.Context, err error) *gqlerror.Error {
+	gqlErr := graphql.DefaultErrorPresenter(ctx, err)
+
+	var extended extendedError
+	if errors.As(err, &extended) {
+		if gqlErr.Extensions == nil {
+			gqlErr.Extensions = make(map[string]interface{})
+		}
+		for key, value := range extended.Extensions() {
+			gqlErr.Extensions[key] = value
+		}
+	}
+	return gqlErr
+}
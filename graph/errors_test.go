@@ -0,0 +1,33 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/sebmartin/collabd/game"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+func TestErrorPresenter(t *testing.T) {
+	err := fmt.Errorf("join failed: %w", game.NewValidationError(game.InvalidPasscodeError, "invalid passcode"))
+
+	presented := ErrorPresenter(context.Background(), err)
+	assert.Equal(t, "join failed: invalid passcode", presented.Message)
+	assert.Equal(t, map[string]interface{}{"code": game.InvalidPasscodeError}, presented.Extensions)
+}
+
+func TestErrorPresenter_MergeExtensions(t *testing.T) {
+	err := gqlerror.WrapPath(nil, game.NewValidationError(game.InvalidPasscodeError, "invalid passcode"))
+	err.Extensions = map[string]interface{}{"field": "passcode"}
+
+	presented := ErrorPresenter(context.Background(), err)
+	assert.Equal(t, map[string]interface{}{"field": "passcode", "code": game.InvalidPasscodeError}, presented.Extensions)
+}
+
+func TestErrorPresenter_NoExtensions(t *testing.T) {
+	presented := ErrorPresenter(context.Background(), fmt.Errorf("oops"))
+	assert.Equal(t, "oops", presented.Message)
+	assert.Nil(t, presented.Extensions)
+}
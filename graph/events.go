@@ -0,0 +1,62 @@
+package graph
+
+import (
+	"context"
+	"log"
+
+	"github.com/sebmartin/collabd/game"
+	"github.com/sebmartin/collabd/game/join_stage"
+	"github.com/sebmartin/collabd/models"
+)
+
+// Prepare server events for the Event interface of the schema. The events of the server and of the
+// join stage have a concrete GraphQL type and are passed through as is. The events of the games are
+// passed through as well when the game declared a GraphQL type for them, see
+// game.RegisterTypedServerEvent(), or presented as a GameEvent with the payload built by the
+// presenter the game registered otherwise, see game.RegisterServerEvent().
+func presentEvents(ctx context.Context, events <-chan models.ServerEvent) <-chan models.ServerEvent {
+	presented := make(chan models.ServerEvent)
+	go func() {
+		defer close(presented)
+		for event := range events {
+			presentedEvent, err := presentEvent(event)
+			if err != nil {
+				log.Printf("Failed to present event of type %s: %s", event.Type(), err)
+				continue
+			}
+
+			select {
+			case presented <- presentedEvent:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return presented
+}
+
+func presentEvent(event models.ServerEvent) (models.ServerEvent, error) {
+	switch event.(type) {
+	case *models.ErrorEvent,
+		*models.GameEvent,
+		*models.SnapshotEvent,
+		*models.SessionAbortedEvent,
+		*models.DidChatEvent,
+		*models.ChatHistoryEvent,
+		*game.ServerShutdownEvent,
+		*join_stage.DidJoinEvent,
+		*join_stage.DidStartEvent,
+		*join_stage.DidLeaveEvent,
+		*join_stage.DidKickEvent,
+		*join_stage.DidLockEvent,
+		*join_stage.HostChangedEvent,
+		*join_stage.DidReadyEvent,
+		*join_stage.DidChooseTeamEvent,
+		*join_stage.DidSeatOrderEvent,
+		*join_stage.StartCountdownEvent,
+		*join_stage.StartCountdownCancelledEvent:
+		return event, nil
+	default:
+		return game.PresentEvent(event)
+	}
+}
@@ -0,0 +1,45 @@
+package graph
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/lru"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/gorilla/websocket"
+	"github.com/sebmartin/collabd/game"
+	"github.com/sebmartin/collabd/graph/generated"
+)
+
+// Serves the GraphQL API of a game server. Queries and mutations are served over HTTP and
+// subscriptions over websockets, players authenticate with the token of their credentials.
+func NewHandler(s *game.Server) http.Handler {
+	srv := handler.New(generated.NewExecutableSchema(generated.Config{
+		Resolvers: &Resolver{GameServer: s},
+	}))
+
+	// Subscriptions are served over websockets, which are upgraded from a GET request
+	srv.AddTransport(transport.Websocket{
+		KeepAlivePingInterval: 10 * time.Second,
+		InitFunc:              WebsocketInit,
+		Upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				return true
+			},
+		},
+	})
+	srv.AddTransport(transport.Options{})
+	srv.AddTransport(transport.GET{})
+	srv.AddTransport(transport.POST{})
+
+	srv.SetErrorPresenter(ErrorPresenter)
+	srv.SetQueryCache(lru.New(1000))
+	srv.Use(extension.Introspection{})
+	srv.Use(extension.AutomaticPersistedQuery{
+		Cache: lru.New(100),
+	})
+
+	return AuthMiddleware(ClientMiddleware(srv))
+}
@@ -13,3 +13,11 @@ type Resolver struct {
 	DB         *gorm.DB
 	GameServer *game.Server
 }
+
+// Value of an optional string argument, empty when it was not given
+func valueOrEmpty(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
@@ -0,0 +1,334 @@
+package graph
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/99designs/gqlgen/client"
+	"github.com/sebmartin/collabd/game"
+	"github.com/sebmartin/collabd/games/connect4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	connect4.Register()
+}
+
+func newTestClient(t *testing.T) (*client.Client, *game.Server, func()) {
+	dbtmpdir, _ := os.MkdirTemp("", "collabd_tests_")
+	dbtmppath := path.Join(dbtmpdir, "_tests.sqlite")
+	server, err := game.NewServer("sqlite", dbtmppath)
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %s", err)
+	}
+	return client.New(NewHandler(server)), server, func() {
+		os.Remove(dbtmppath)
+		os.Remove(dbtmpdir)
+	}
+}
+
+type credentialsResponse struct {
+	Player struct {
+		ID   string
+		Name string
+	}
+	Token string
+}
+
+// Start a Connect 4 session and join it, returns the session's code and the player's token
+func startAndJoin(t *testing.T, c *client.Client, name string) (string, string) {
+	var started struct {
+		StartSession struct {
+			Code   string
+			Status string
+		}
+	}
+	c.MustPost(`mutation { startSession(gameName: "Connect4") { code status } }`, &started)
+	require.Equal(t, "LOBBY", started.StartSession.Status)
+
+	code := started.StartSession.Code
+	return code, join(t, c, code, name)
+}
+
+func join(t *testing.T, c *client.Client, code string, name string) string {
+	var joined struct {
+		JoinSession credentialsResponse
+	}
+	c.MustPost(
+		`mutation($code: String!, $name: String!) { joinSession(code: $code, name: $name) { player { id name } token } }`,
+		&joined, client.Var("code", code), client.Var("name", name),
+	)
+	require.Equal(t, name, joined.JoinSession.Player.Name)
+	require.NotEmpty(t, joined.JoinSession.Token)
+	return joined.JoinSession.Token
+}
+
+func bearer(token string) client.Option {
+	return client.AddHeader("Authorization", "Bearer "+token)
+}
+
+// Make the request from another address than the client's default one
+func from(address string) client.Option {
+	return func(r *client.Request) {
+		r.HTTP.RemoteAddr = address
+	}
+}
+
+func TestResolver_Games(t *testing.T) {
+	c, _, cleanup := newTestClient(t)
+	defer cleanup()
+
+	var resp struct {
+		Games []struct {
+			Key     string
+			Options []struct {
+				Key     string
+				Default interface{}
+			}
+		}
+	}
+	c.MustPost(`{ games { key options { key default } } }`, &resp)
+	require.Len(t, resp.Games, 1)
+	assert.Equal(t, connect4.Info.Key, resp.Games[0].Key)
+	assert.Equal(t, connect4.FirstPlayerOption, resp.Games[0].Options[0].Key)
+	assert.Equal(t, connect4.FirstPlayerJoinOrder, resp.Games[0].Options[0].Default)
+}
+
+func TestResolver_JoinSession(t *testing.T) {
+	c, server, cleanup := newTestClient(t)
+	defer cleanup()
+
+	code, token := startAndJoin(t, c, "Annie")
+	player, err := server.Authenticate(token)
+	require.Nil(t, err)
+	assert.Equal(t, "Annie", player.Name)
+
+	var resp struct {
+		Session struct {
+			Players []struct{ Name string }
+			State   struct{ AcceptingPlayers bool }
+		}
+	}
+	c.MustPost(`query($code: String!) { session(code: $code) { players { name } state { acceptingPlayers } } }`, &resp, client.Var("code", code))
+	assert.Equal(t, []struct{ Name string }{{"Annie"}}, resp.Session.Players)
+	assert.True(t, resp.Session.State.AcceptingPlayers)
+}
+
+func TestResolver_Session_PlayersWhileJoining(t *testing.T) {
+	c, _, cleanup := newTestClient(t)
+	defer cleanup()
+
+	code, _ := startAndJoin(t, c, "Annie")
+	joined := make(chan struct{})
+	go func() {
+		defer close(joined)
+		join(t, c, code, "Steve")
+	}()
+
+	// The players are read while Steve is being added to them
+	var resp struct {
+		Session struct{ Players []struct{ Name string } }
+	}
+	for done := false; !done; {
+		select {
+		case <-joined:
+			done = true
+		default:
+		}
+		c.MustPost(`query($code: String!) { session(code: $code) { players { name } } }`, &resp, client.Var("code", code))
+	}
+	assert.Equal(t, []struct{ Name string }{{"Annie"}, {"Steve"}}, resp.Session.Players)
+}
+
+func TestResolver_SendAction(t *testing.T) {
+	c, _, cleanup := newTestClient(t)
+	defer cleanup()
+
+	_, token := startAndJoin(t, c, "Annie")
+	var resp struct{ SendAction bool }
+	c.MustPost(`mutation { sendAction(type: "CHAT", payload: {message: "Hello"}) }`, &resp, bearer(token))
+	assert.True(t, resp.SendAction)
+}
+
+func TestResolver_SendAction_Unauthenticated(t *testing.T) {
+	c, _, cleanup := newTestClient(t)
+	defer cleanup()
+
+	startAndJoin(t, c, "Annie")
+	var resp struct{ SendAction bool }
+	tests := []struct {
+		name     string
+		options  []client.Option
+		expected string
+	}{
+		{name: "no token", expected: "a player token is required"},
+		{name: "invalid token", options: []client.Option{bearer("not-a-token")}, expected: "invalid player token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := c.Post(`mutation { sendAction(type: "CHAT", payload: {message: "Hello"}) }`, &resp, tt.options...)
+			assert.ErrorContains(t, err, tt.expected)
+		})
+	}
+}
+
+func TestResolver_SendAction_ValidationError(t *testing.T) {
+	c, _, cleanup := newTestClient(t)
+	defer cleanup()
+
+	_, token := startAndJoin(t, c, "Annie")
+	var resp struct{ SendAction bool }
+	err := c.Post(`mutation { sendAction(type: "DANCE") }`, &resp, bearer(token))
+	require.NotNil(t, err)
+	// The error's code is presented as an extension, along with the path set by gqlgen
+	assert.Contains(t, err.Error(), `"extensions":{"code":"UNKNOWN_EVENT_TYPE"}`)
+	assert.Contains(t, err.Error(), `"path":["sendAction"]`)
+}
+
+type eventResponse struct {
+	Events struct {
+		Type     string
+		Sequence int
+		Message  string
+	}
+}
+
+const eventsSubscription = `subscription { events { type sequence ... on DidChatEvent { message } } }`
+
+func TestResolver_Events_InitPayload(t *testing.T) {
+	c, _, cleanup := newTestClient(t)
+	defer cleanup()
+
+	_, token := startAndJoin(t, c, "Annie")
+	subscription := c.WebsocketWithPayload(eventsSubscription, map[string]interface{}{"Authorization": "Bearer " + token})
+	defer subscription.Close()
+
+	var resp eventResponse
+	require.Nil(t, subscription.Next(&resp))
+	assert.Equal(t, "DID_JOIN", resp.Events.Type)
+	joinedAt := resp.Events.Sequence
+
+	c.MustPost(`mutation { sendAction(type: "CHAT", payload: {message: "Hello"}) }`, &struct{ SendAction bool }{}, bearer(token))
+	require.Nil(t, subscription.Next(&resp))
+	assert.Equal(t, "DID_CHAT", resp.Events.Type)
+	assert.Equal(t, "Hello", resp.Events.Message)
+	assert.Greater(t, resp.Events.Sequence, joinedAt)
+}
+
+func TestResolver_Events_Header(t *testing.T) {
+	c, _, cleanup := newTestClient(t)
+	defer cleanup()
+
+	_, token := startAndJoin(t, c, "Annie")
+	// The token is read from the request that is upgraded to a websocket
+	subscription := c.Websocket(eventsSubscription, bearer(token))
+	defer subscription.Close()
+
+	var resp eventResponse
+	require.Nil(t, subscription.Next(&resp))
+	assert.Equal(t, "DID_JOIN", resp.Events.Type)
+}
+
+func TestResolver_Events_Unauthenticated(t *testing.T) {
+	c, _, cleanup := newTestClient(t)
+	defer cleanup()
+
+	subscription := c.WebsocketWithPayload(eventsSubscription, map[string]interface{}{"Authorization": "Bearer not-a-token"})
+	defer subscription.Close()
+
+	var resp eventResponse
+	assert.ErrorContains(t, subscription.Next(&resp), "invalid player token")
+}
+
+func TestResolver_Events_Connect4(t *testing.T) {
+	c, _, cleanup := newTestClient(t)
+	defer cleanup()
+
+	code, token := startAndJoin(t, c, "Annie")
+	join(t, c, code, "Steve")
+
+	subscription := c.WebsocketWithPayload(
+		`subscription { events {
+			type
+			... on PlayerTurnEvent { activePlayer { name } }
+			... on DidDropPieceEvent { piece slot row }
+		} }`,
+		map[string]interface{}{"Authorization": "Bearer " + token},
+	)
+	defer subscription.Close()
+
+	// Connect 4 starts once both players have joined, Annie plays first
+	var resp struct {
+		Events struct {
+			Type         string
+			ActivePlayer struct{ Name string }
+			Piece        string
+			Slot         int
+			Row          int
+		}
+	}
+	for resp.Events.Type != string(connect4.PlayerTurnEventType) {
+		require.Nil(t, subscription.Next(&resp))
+	}
+	assert.Equal(t, "Annie", resp.Events.ActivePlayer.Name)
+
+	c.MustPost(`mutation { sendAction(type: "DROP_PIECE", payload: {slot: 3}) }`, &struct{ SendAction bool }{}, bearer(token))
+	for resp.Events.Type != string(connect4.DidDropPieceEventType) {
+		require.Nil(t, subscription.Next(&resp))
+	}
+	assert.Equal(t, "RED", resp.Events.Piece)
+	assert.Equal(t, 3, resp.Events.Slot)
+	assert.Equal(t, 5, resp.Events.Row)
+}
+
+func TestResolver_JoinSession_PasscodeAttempts(t *testing.T) {
+	c, _, cleanup := newTestClient(t)
+	defer cleanup()
+
+	var started struct {
+		StartSession struct {
+			Code string
+		}
+	}
+	c.MustPost(`mutation { startSession(gameName: "Connect4", visibility: PRIVATE, passcode: "hunter2") { code } }`, &started)
+	code := started.StartSession.Code
+
+	joinQuery := `mutation($code: String!, $passcode: String) { joinSession(code: $code, name: "Steve", passcode: $passcode) { token } }`
+	var resp struct{ JoinSession struct{ Token string } }
+	for i := 0; i < game.MaxPasscodeAttempts; i++ {
+		err := c.Post(joinQuery, &resp, client.Var("code", code), client.Var("passcode", "guess"), from("203.0.113.1:4321"))
+		require.NotNil(t, err)
+		assert.Contains(t, err.Error(), game.InvalidPasscodeError)
+	}
+	err := c.Post(joinQuery, &resp, client.Var("code", code), client.Var("passcode", "hunter2"), from("203.0.113.1:1234"))
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), game.TooManyAttemptsError)
+
+	// Only the client who guessed is locked out
+	c.MustPost(joinQuery, &resp, client.Var("code", code), client.Var("passcode", "hunter2"))
+	assert.NotEmpty(t, resp.JoinSession.Token)
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	assert.Equal(t, "abc", bearerToken("Bearer abc"))
+	assert.Equal(t, "", bearerToken(""))
+
+	ctx, err := WebsocketInit(context.Background(), map[string]interface{}{"Authorization": "Bearer abc"})
+	require.Nil(t, err)
+	assert.Equal(t, "abc", ctx.Value(playerTokenKey))
+}
+
+func TestClientMiddleware(t *testing.T) {
+	request := httptest.NewRequest(http.MethodPost, "/", nil)
+	request.RemoteAddr = "203.0.113.1:4321"
+	assert.Equal(t, "203.0.113.1", clientAddress(request))
+	request.RemoteAddr = "[2001:db8::1]:4321"
+	assert.Equal(t, "2001:db8::1", clientAddress(request))
+	request.RemoteAddr = "pipe"
+	assert.Equal(t, "pipe", clientAddress(request))
+}
@@ -5,26 +5,97 @@ package graph
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"time"
 
+	"github.com/sebmartin/collabd/game"
 	"github.com/sebmartin/collabd/graph/generated"
 	"github.com/sebmartin/collabd/models"
 )
 
+// Default is the resolver for the default field.
+func (r *gameOptionResolver) Default(ctx context.Context, obj *game.OptionSpec) (models.JSON, error) {
+	return json.Marshal(obj.Default)
+}
+
+// Min is the resolver for the min field.
+func (r *gameOptionResolver) Min(ctx context.Context, obj *game.OptionSpec) (*int, error) {
+	if obj.Type != game.IntOptionType {
+		return nil, nil
+	}
+	return &obj.Min, nil
+}
+
+// Max is the resolver for the max field.
+func (r *gameOptionResolver) Max(ctx context.Context, obj *game.OptionSpec) (*int, error) {
+	if obj.Type != game.IntOptionType {
+		return nil, nil
+	}
+	return &obj.Max, nil
+}
+
 // StartSession is the resolver for the startSession field.
-func (r *mutationResolver) StartSession(ctx context.Context, gameName *string) (*models.Session, error) {
-	return r.GameServer.NewSession(ctx, gameName)
+func (r *mutationResolver) StartSession(ctx context.Context, gameName *string, options models.JSON, visibility *models.SessionVisibility, passcode *string) (*models.Session, error) {
+	if gameName == nil {
+		return nil, fmt.Errorf("a game name is required to start a session")
+	}
+	if visibility != nil && *visibility == models.SessionPrivate {
+		return r.GameServer.NewPrivateSession(ctx, *gameName, json.RawMessage(options), valueOrEmpty(passcode))
+	}
+	if passcode != nil {
+		return nil, fmt.Errorf("only private sessions can have a passcode")
+	}
+	return r.GameServer.NewSession(ctx, *gameName, json.RawMessage(options))
 }
 
 // JoinSession is the resolver for the joinSession field.
-func (r *mutationResolver) JoinSession(ctx context.Context, name string, code string) (*models.Player, error) {
-	// return models.NewPlayer(r.DB, name, code)
-	return nil, fmt.Errorf("obtain session from the repo (in memory session)")
+func (r *mutationResolver) JoinSession(ctx context.Context, name string, code string, passcode *string) (*game.PlayerCredentials, error) {
+	return r.GameServer.JoinSession(ctx, code, name, valueOrEmpty(passcode))
 }
 
-// Session is the resolver for the session field.
-func (r *playerResolver) Session(ctx context.Context, obj *models.Player) (*models.Session, error) {
-	panic(fmt.Errorf("not implemented"))
+// JoinSessionWithInvite is the resolver for the joinSessionWithInvite field.
+func (r *mutationResolver) JoinSessionWithInvite(ctx context.Context, name string, invite string) (*game.PlayerCredentials, error) {
+	return r.GameServer.JoinSessionWithInvite(ctx, invite, name)
+}
+
+// CreateInvite is the resolver for the createInvite field.
+func (r *mutationResolver) CreateInvite(ctx context.Context, expiresInSeconds *int) (string, error) {
+	player, err := r.authenticatedPlayer(ctx)
+	if err != nil {
+		return "", err
+	}
+	ttl := game.DefaultInviteTTL
+	if expiresInSeconds != nil {
+		ttl = time.Duration(*expiresInSeconds) * time.Second
+	}
+	return r.GameServer.IssueInvite(player.Session, ttl)
+}
+
+// LeaveQueue is the resolver for the leaveQueue field.
+func (r *mutationResolver) LeaveQueue(ctx context.Context, ticketID string) (bool, error) {
+	err := r.GameServer.Matchmaker.Leave(ticketID)
+	return err == nil, err
+}
+
+// SendAction is the resolver for the sendAction field.
+func (r *mutationResolver) SendAction(ctx context.Context, typeArg string, payload models.JSON) (bool, error) {
+	player, err := r.authenticatedPlayer(ctx)
+	if err != nil {
+		return false, err
+	}
+	err = r.GameServer.HandleAction(ctx, player, models.EventType(typeArg), json.RawMessage(payload))
+	return err == nil, err
+}
+
+// Games is the resolver for the games field.
+func (r *queryResolver) Games(ctx context.Context) ([]*game.GameInfo, error) {
+	games := r.GameServer.Games()
+	infos := make([]*game.GameInfo, len(games))
+	for i := range games {
+		infos[i] = &games[i]
+	}
+	return infos, nil
 }
 
 // GamesList is the resolver for the gamesList field.
@@ -34,7 +105,10 @@ func (r *queryResolver) GamesList(ctx context.Context) ([]string, error) {
 
 // Sessions is the resolver for the sessions field.
 func (r *queryResolver) Sessions(ctx context.Context) ([]*models.Session, error) {
-	sessions := r.GameServer.ActiveSessions()
+	sessions, err := r.GameServer.ListedSessions(ctx)
+	if err != nil {
+		return nil, err
+	}
 	if sessions != nil {
 		return sessions, nil
 	} else {
@@ -42,15 +116,79 @@ func (r *queryResolver) Sessions(ctx context.Context) ([]*models.Session, error)
 	}
 }
 
+// Session is the resolver for the session field.
+func (r *queryResolver) Session(ctx context.Context, code string, passcode *string, invite *string) (*models.Session, error) {
+	access, err := r.sessionAccess(ctx, passcode, invite)
+	if err != nil {
+		return nil, err
+	}
+	return r.GameServer.AccessSession(ctx, code, access)
+}
+
+// Queue is the resolver for the queue field.
+func (r *queryResolver) Queue(ctx context.Context, gameKey string) (*game.QueueStats, error) {
+	stats := r.GameServer.Matchmaker.Stats(gameKey)
+	return &stats, nil
+}
+
+// Events is the resolver for the events field.
+func (r *subscriptionResolver) Events(ctx context.Context, afterSequence *int) (<-chan models.ServerEvent, error) {
+	player, err := r.authenticatedPlayer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var after uint
+	if afterSequence != nil {
+		if *afterSequence < 0 {
+			return nil, fmt.Errorf("afterSequence must not be negative")
+		}
+		after = uint(*afterSequence)
+	}
+	events, err := r.GameServer.SubscribePlayerEvents(ctx, player, after)
+	if err != nil {
+		return nil, err
+	}
+	return presentEvents(ctx, events), nil
+}
+
+// SpectateSession is the resolver for the spectateSession field.
+func (r *subscriptionResolver) SpectateSession(ctx context.Context, sessionCode string, passcode *string, invite *string) (<-chan models.ServerEvent, error) {
+	access, err := r.sessionAccess(ctx, passcode, invite)
+	if err != nil {
+		return nil, err
+	}
+	events, err := r.GameServer.SpectateSession(ctx, sessionCode, access)
+	if err != nil {
+		return nil, err
+	}
+	return presentEvents(ctx, events), nil
+}
+
+// Matchmaking is the resolver for the matchmaking field.
+func (r *subscriptionResolver) Matchmaking(ctx context.Context, gameKey string, name string, skill *int, maxSkillGap *int) (<-chan *game.MatchmakingUpdate, error) {
+	var options game.QueueOptions
+	if skill != nil {
+		options.Skill = *skill
+	}
+	if maxSkillGap != nil {
+		options.MaxSkillGap = *maxSkillGap
+	}
+	return r.GameServer.Matchmaker.Queue(ctx, gameKey, name, options)
+}
+
+// GameOption returns generated.GameOptionResolver implementation.
+func (r *Resolver) GameOption() generated.GameOptionResolver { return &gameOptionResolver{r} }
+
 // Mutation returns generated.MutationResolver implementation.
 func (r *Resolver) Mutation() generated.MutationResolver { return &mutationResolver{r} }
 
-// Player returns generated.PlayerResolver implementation.
-func (r *Resolver) Player() generated.PlayerResolver { return &playerResolver{r} }
-
 // Query returns generated.QueryResolver implementation.
 func (r *Resolver) Query() generated.QueryResolver { return &queryResolver{r} }
 
+// Subscription returns generated.SubscriptionResolver implementation.
+func (r *Resolver) Subscription() generated.SubscriptionResolver { return &subscriptionResolver{r} }
+
+type gameOptionResolver struct{ *Resolver }
 type mutationResolver struct{ *Resolver }
-type playerResolver struct{ *Resolver }
 type queryResolver struct{ *Resolver }
+type subscriptionResolver struct{ *Resolver }
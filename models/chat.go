@@ -0,0 +1,322 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ChatEventType        EventType = "CHAT"
+	DidChatEventType     EventType = "DID_CHAT"
+	ChatHistoryEventType EventType = "CHAT_HISTORY"
+
+	// Maximum number of characters in a chat message
+	MaxChatMessageLength = 500
+	// Number of public chat messages sent to players who join the session
+	ChatHistorySize = 50
+)
+
+// Who receives a chat message
+type ChatScope string
+
+const (
+	// Every player and spectator of the session
+	ChatPublic ChatScope = "PUBLIC"
+	// The players of the sender's team
+	ChatTeam ChatScope = "TEAM"
+)
+
+func (s ChatScope) MarshalGQL(w io.Writer) {
+	io.WriteString(w, strconv.Quote(string(s)))
+}
+
+func (s *ChatScope) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("chat scopes must be strings")
+	}
+	*s = ChatScope(str)
+	return nil
+}
+
+// Optional interface for a `StageRunner` whose players are split into teams, which lets them
+// send chat messages to their team only. Teammates is called by the session while the stage is
+// not processing an event. The teams are kept by the session once the stage ends, so that the
+// players of a lobby that sets up teams can still reach their team in the game's later stages.
+type TeamStage interface {
+	// The players of the player's team, including the player, or none if it has no team
+	Teammates(player *Player) []*Player
+}
+
+// Event sent by a player to chat with the other participants of the session. Chat is handled by
+// the session itself so stages never receive this event.
+type ChatEvent struct {
+	PlayerEvent
+	Message string
+	Scope   ChatScope
+}
+
+func NewChatEvent(ctx context.Context, sender *Player, message string, scope ChatScope) *ChatEvent {
+	return &ChatEvent{
+		PlayerEvent: NewPlayerEvent(ctx, ChatEventType, sender),
+		Message:     message,
+		Scope:       scope,
+	}
+}
+
+// Chat message sent to the participants of a session
+type DidChatEvent struct {
+	ServerEvent
+	Sender  *Player
+	Message string
+	Scope   ChatScope
+	SentAt  time.Time
+}
+
+func NewDidChatEvent(sender *Player, message string, scope ChatScope, sentAt time.Time) *DidChatEvent {
+	return &DidChatEvent{
+		ServerEvent: NewServerEvent(DidChatEventType),
+		Sender:      sender,
+		Message:     message,
+		Scope:       scope,
+		SentAt:      sentAt,
+	}
+}
+
+// How a chat message is recorded in the session's event log, the sender may no longer be part of
+// the session when the message is read back
+type chatRecord struct {
+	SenderID   uint
+	SenderName string
+	Message    string
+	Scope      ChatScope
+	SentAt     time.Time
+}
+
+func (e *DidChatEvent) MarshalJSON() ([]byte, error) {
+	record := chatRecord{
+		SenderName: e.Sender.Name,
+		Message:    e.Message,
+		Scope:      e.Scope,
+		SentAt:     e.SentAt,
+	}
+	if e.Sender.Model != nil {
+		record.SenderID = e.Sender.ID
+	}
+	return json.Marshal(record)
+}
+
+// The latest public chat messages of a session, sent to a player who joins it
+type ChatHistoryEvent struct {
+	ServerEvent
+	Messages []*DidChatEvent
+}
+
+func NewChatHistoryEvent(messages []*DidChatEvent) *ChatHistoryEvent {
+	return &ChatHistoryEvent{
+		ServerEvent: NewServerEvent(ChatHistoryEventType),
+		Messages:    messages,
+	}
+}
+
+// Relay a chat message from one of the session's players. This is called from the session's
+// event loop while the stage is not processing an event.
+func (s *Session) handleChat(event *ChatEvent, stage StageRunner) {
+	sender := event.Sender()
+	if err := s.validateChat(event); err != nil {
+		sender.Send(NewErrorEvent(err))
+		return
+	}
+
+	message := NewDidChatEvent(sender, strings.TrimSpace(event.Message), event.Scope, s.timers.clock.Now())
+	switch event.Scope {
+	case ChatTeam:
+		teammates := s.teammates(sender, stage)
+		if len(teammates) == 0 {
+			sender.Send(NewErrorEvent(fmt.Errorf("player %s is not part of a team", sender.Name)))
+			return
+		}
+		// Team messages are private, they are not published to spectators
+		for _, p := range teammates {
+			p.Send(message)
+		}
+	default:
+		s.rememberChat(message)
+		s.Notify(message)
+	}
+}
+
+// The players of a player's team, according to the stage or else to the teams kept from the
+// previous stages. Players who have left the session are not part of their team anymore.
+func (s *Session) teammates(player *Player, stage StageRunner) []*Player {
+	if teams, ok := stage.(TeamStage); ok {
+		if teammates := teams.Teammates(player); len(teammates) > 0 {
+			return teammates
+		}
+	}
+
+	players := s.players()
+	for _, team := range s.teams {
+		if !containsID(team, player.ID) {
+			continue
+		}
+		var teammates []*Player
+		for _, id := range team {
+			if p, err := FindPlayer(players, id); err == nil {
+				teammates = append(teammates, p)
+			}
+		}
+		return teammates
+	}
+	return nil
+}
+
+// Keep the teams of a stage that has ended, stages without teams leave the kept teams as they are
+func (s *Session) keepTeams(stage StageRunner) {
+	teamStage, ok := stage.(TeamStage)
+	if !ok {
+		return
+	}
+
+	var teams [][]uint
+	for _, p := range s.players() {
+		if inTeam(teams, p.ID) {
+			continue
+		}
+		var team []uint
+		for _, teammate := range teamStage.Teammates(p) {
+			team = append(team, teammate.ID)
+		}
+		if len(team) > 0 {
+			teams = append(teams, team)
+		}
+	}
+	if len(teams) == 0 {
+		return
+	}
+	s.teams = teams
+
+	if s.db == nil {
+		return
+	}
+	encoded, err := json.Marshal(teams)
+	if err != nil {
+		log.Printf(`Failed to encode the teams of session "%s": %s`, s.Code, err)
+		return
+	}
+	if err := s.db.Model(&Session{}).Where("id = ?", s.ID).Update("teams", JSON(encoded)).Error; err != nil {
+		log.Printf(`Failed to save the teams of session "%s": %s`, s.Code, err)
+	}
+}
+
+// Reload the kept teams when the session is resumed
+func (s *Session) restoreTeams() error {
+	if len(s.Teams) == 0 {
+		return nil
+	}
+	return json.Unmarshal(s.Teams, &s.teams)
+}
+
+func inTeam(teams [][]uint, id uint) bool {
+	for _, team := range teams {
+		if containsID(team, id) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsID(ids []uint, id uint) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Session) validateChat(event *ChatEvent) error {
+	if !containsPlayer(s.players(), event.Sender()) {
+		return fmt.Errorf(`player %s is not part of session "%s"`, event.Sender().Name, s.Code)
+	}
+	if event.Scope != ChatPublic && event.Scope != ChatTeam {
+		return fmt.Errorf("unknown chat scope: %s", event.Scope)
+	}
+
+	message := strings.TrimSpace(event.Message)
+	if message == "" {
+		return fmt.Errorf("chat messages cannot be empty")
+	}
+	if utf8.RuneCountInString(message) > MaxChatMessageLength {
+		return fmt.Errorf("chat messages cannot be longer than %d characters", MaxChatMessageLength)
+	}
+	return nil
+}
+
+func containsPlayer(players []*Player, player *Player) bool {
+	for _, p := range players {
+		if p == player {
+			return true
+		}
+	}
+	return false
+}
+
+// Keep a public message in the session's chat history, only the latest messages are kept
+func (s *Session) rememberChat(message *DidChatEvent) {
+	s.chatMu.Lock()
+	defer s.chatMu.Unlock()
+
+	if len(s.chat) == ChatHistorySize {
+		s.chat = s.chat[1:]
+	}
+	s.chat = append(s.chat, message)
+}
+
+// The latest public chat messages of the session, oldest first
+func (s *Session) ChatHistory() []*DidChatEvent {
+	s.chatMu.Lock()
+	defer s.chatMu.Unlock()
+
+	messages := make([]*DidChatEvent, len(s.chat))
+	copy(messages, s.chat)
+	return messages
+}
+
+// Reload the chat history from the session's event log when the session is resumed
+func (s *Session) restoreChat() error {
+	var entries []SessionEvent
+	err := s.db.Where("session_id = ? AND type = ?", s.ID, DidChatEventType).Order("sequence").Find(&entries).Error
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		var record chatRecord
+		if err := json.Unmarshal(entry.Payload, &record); err != nil {
+			return err
+		}
+		if record.Scope != ChatPublic {
+			continue
+		}
+
+		sender, err := FindPlayer(s.Players, record.SenderID)
+		if err != nil {
+			// The sender has left the session since
+			sender = &Player{Model: &gorm.Model{ID: record.SenderID}, Name: record.SenderName}
+		}
+		message := NewDidChatEvent(sender, record.Message, record.Scope, record.SentAt)
+		message.setSequence(entry.Sequence)
+		s.rememberChat(message)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Stage with fixed teams that reports the events it receives, it hands off to its next stage
+// when it receives a NEXT event
+type teamStage struct {
+	teams    map[*Player][]*Player
+	received chan EventType
+	next     StageRunner
+}
+
+func (s *teamStage) Run(events <-chan PlayerEvent) StageRunner {
+	for event := range events {
+		if event.Type() == "NEXT" {
+			return s.next
+		}
+		if event != syncEvent {
+			s.received <- event.Type()
+		}
+	}
+	return nil
+}
+
+func (s *teamStage) Teammates(player *Player) []*Player {
+	return s.teams[player]
+}
+
+func newChatSession(t *testing.T, stage StageRunner) (*Session, *FakeClock, func()) {
+	db, cleanup := ConnectWithTestDB()
+	clock := NewFakeClock(time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC))
+	session, err := createSession(db, NewGame("TestGame", stage), seededCodeGenerator{predictableSeed()}, clock)
+	require.Nil(t, err)
+	return session, clock, cleanup
+}
+
+func addPlayers(t *testing.T, session *Session, names ...string) []*Player {
+	players := make([]*Player, 0, len(names))
+	for _, name := range names {
+		player, err := NewPlayer(session.db, name)
+		require.Nil(t, err)
+		session.AddPlayer(player)
+		players = append(players, player)
+	}
+	return players
+}
+
+func TestSession_Chat_Public(t *testing.T) {
+	stage := &teamStage{received: make(chan EventType, 10)}
+	session, clock, cleanup := newChatSession(t, stage)
+	defer cleanup()
+	players := addPlayers(t, session, "Annie", "Steve")
+	spectator, err := session.Spectate(context.Background())
+	require.Nil(t, err)
+	receive(t, spectator) // Snapshot
+
+	session.HandlePlayerEvent(NewChatEvent(context.Background(), players[0], "  Hello!  ", ChatPublic))
+	session.HandlePlayerEvent(NewPlayerEvent(context.Background(), "PING", players[0]))
+
+	for _, events := range []<-chan ServerEvent{players[0].ServerEvents, players[1].ServerEvents, spectator} {
+		event, _ := receive(t, events)
+		require.IsType(t, &DidChatEvent{}, event)
+		message := event.(*DidChatEvent)
+		assert.Equal(t, players[0], message.Sender)
+		assert.Equal(t, "Hello!", message.Message)
+		assert.Equal(t, ChatPublic, message.Scope)
+		assert.Equal(t, clock.Now(), message.SentAt)
+	}
+	assert.Equal(t, EventType("PING"), <-stage.received, "The stage should not receive chat events")
+
+	history := session.ChatHistory()
+	require.Len(t, history, 1)
+	assert.Equal(t, "Hello!", history[0].Message)
+}
+
+func TestSession_Chat_Team(t *testing.T) {
+	stage := &teamStage{received: make(chan EventType, 10)}
+	session, _, cleanup := newChatSession(t, stage)
+	defer cleanup()
+	players := addPlayers(t, session, "Annie", "Steve", "Mikey")
+	stage.teams = map[*Player][]*Player{
+		players[0]: {players[0], players[1]},
+		players[1]: {players[0], players[1]},
+	}
+	spectator, err := session.Spectate(context.Background())
+	require.Nil(t, err)
+	receive(t, spectator) // Snapshot
+
+	session.HandlePlayerEvent(NewChatEvent(context.Background(), players[1], "Go left", ChatTeam))
+	session.HandlePlayerEvent(NewChatEvent(context.Background(), players[2], "Me too", ChatTeam))
+
+	for _, teammate := range players[:2] {
+		event, _ := receive(t, teammate.ServerEvents)
+		require.IsType(t, &DidChatEvent{}, event)
+		assert.Equal(t, "Go left", event.(*DidChatEvent).Message)
+	}
+
+	// Mikey has no team, they only get an error
+	event, _ := receive(t, players[2].ServerEvents)
+	require.IsType(t, &ErrorEvent{}, event)
+	assert.Equal(t, "player Mikey is not part of a team", event.(*ErrorEvent).Message())
+	assert.Empty(t, spectator, "Team messages should not be published")
+	assert.Empty(t, session.ChatHistory(), "Team messages should not be kept in the history")
+}
+
+func TestSession_Chat_TeamAfterStage(t *testing.T) {
+	// The teams are set up by the first stage, the next stage has none
+	stage := &teamStage{received: make(chan EventType, 10), next: &teamStage{}}
+	session, _, cleanup := newChatSession(t, stage)
+	defer cleanup()
+	players := addPlayers(t, session, "Annie", "Steve", "Mikey")
+	stage.teams = map[*Player][]*Player{
+		players[0]: {players[0], players[2]},
+		players[2]: {players[0], players[2]},
+		players[1]: {players[1]},
+	}
+	session.HandlePlayerEvent(NewPlayerEvent(context.Background(), "NEXT", players[0]))
+	session.HandlePlayerEvent(NewChatEvent(context.Background(), players[2], "Go left", ChatTeam))
+
+	for _, teammate := range []*Player{players[0], players[2]} {
+		event, _ := receive(t, teammate.ServerEvents)
+		require.IsType(t, &DidChatEvent{}, event)
+		assert.Equal(t, "Go left", event.(*DidChatEvent).Message)
+	}
+	assert.Empty(t, players[1].ServerEvents)
+
+	// Players who left are no longer part of their team
+	session.RemovePlayer(players[0])
+	session.HandlePlayerEvent(NewChatEvent(context.Background(), players[2], "Anyone?", ChatTeam))
+	event, _ := receive(t, players[2].ServerEvents)
+	require.IsType(t, &DidChatEvent{}, event)
+	assert.Empty(t, players[0].ServerEvents)
+
+	// The teams are saved for when the session is resumed
+	var saved Session
+	require.Nil(t, session.db.First(&saved, session.ID).Error)
+	require.Nil(t, saved.restoreTeams())
+	assert.Equal(t, [][]uint{{players[0].ID, players[2].ID}, {players[1].ID}}, saved.teams)
+}
+
+func TestSession_Chat_Invalid(t *testing.T) {
+	session, _, cleanup := newChatSession(t, &countingStage{})
+	defer cleanup()
+	players := addPlayers(t, session, "Annie")
+	outsider, _ := NewPlayer(session.db, "Steve")
+	outsider.Session = session
+
+	tests := []struct {
+		sender   *Player
+		message  string
+		scope    ChatScope
+		expected string
+	}{
+		{players[0], "   ", ChatPublic, "chat messages cannot be empty"},
+		{players[0], strings.Repeat("é", MaxChatMessageLength+1), ChatPublic, "chat messages cannot be longer than 500 characters"},
+		{players[0], "Hi", "ALL", "unknown chat scope: ALL"},
+		{players[0], "Hi", ChatTeam, "player Annie is not part of a team"},
+		{outsider, "Hi", ChatPublic, `player Steve is not part of session "NBDX"`},
+	}
+	for _, test := range tests {
+		session.HandlePlayerEvent(NewChatEvent(context.Background(), test.sender, test.message, test.scope))
+		event, _ := receive(t, test.sender.ServerEvents)
+		require.IsType(t, &ErrorEvent{}, event)
+		assert.Equal(t, test.expected, event.(*ErrorEvent).Message())
+	}
+
+	// The longest message is accepted
+	session.HandlePlayerEvent(NewChatEvent(context.Background(), players[0], strings.Repeat("é", MaxChatMessageLength), ChatPublic))
+	event, _ := receive(t, players[0].ServerEvents)
+	assert.IsType(t, &DidChatEvent{}, event)
+}
+
+func TestSession_Chat_History(t *testing.T) {
+	session, _, cleanup := newChatSession(t, &countingStage{})
+	defer cleanup()
+	players := addPlayers(t, session, "Annie")
+
+	for i := 0; i < ChatHistorySize+1; i++ {
+		session.HandlePlayerEvent(NewChatEvent(context.Background(), players[0], "Anyone?", ChatPublic))
+	}
+	session.HandlePlayerEvent(NewChatEvent(context.Background(), players[0], "Hello", ChatPublic))
+	for i := 0; i < ChatHistorySize+2; i++ {
+		receive(t, players[0].ServerEvents)
+	}
+
+	newcomer := addPlayers(t, session, "Steve")[0]
+	event, _ := receive(t, newcomer.ServerEvents)
+	require.IsType(t, &ChatHistoryEvent{}, event)
+	messages := event.(*ChatHistoryEvent).Messages
+	require.Len(t, messages, ChatHistorySize)
+	assert.Equal(t, "Hello", messages[len(messages)-1].Message)
+
+	state, err := session.State(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, messages, state.Chat)
+
+	// The history only repeats messages that are already logged
+	logged, err := session.EventLog()
+	require.Nil(t, err)
+	for _, entry := range logged {
+		assert.NotEqual(t, ChatHistoryEventType, entry.Type)
+	}
+}
+
+func TestSession_Chat_Resume(t *testing.T) {
+	// The stage does not save snapshots so the session resumes with a new stage
+	session, clock, cleanup := newChatSession(t, &teamStage{})
+	defer cleanup()
+	players := addPlayers(t, session, "Annie", "Steve")
+	session.HandlePlayerEvent(NewChatEvent(context.Background(), players[0], "Hello", ChatPublic))
+	session.HandlePlayerEvent(NewChatEvent(context.Background(), players[1], "Hi", ChatPublic))
+	receive(t, players[0].ServerEvents)
+	receive(t, players[0].ServerEvents)
+	session.RemovePlayer(players[1])
+
+	require.Nil(t, session.Suspend())
+	waitForDone(t, session)
+
+	sessions, err := LiveSessions(session.db)
+	require.Nil(t, err)
+	require.Len(t, sessions, 1)
+	resumed := sessions[0]
+	require.Nil(t, ResumeSession(session.db, resumed, NewGame("TestGame", &teamStage{}), clock))
+	defer resumed.Abort("Test is over")
+
+	history := resumed.ChatHistory()
+	require.Len(t, history, 2)
+	assert.Equal(t, "Annie", history[0].Sender.Name)
+	assert.Same(t, resumed.Players[0], history[0].Sender)
+	assert.Equal(t, "Hello", history[0].Message)
+	assert.Equal(t, clock.Now(), history[0].SentAt.In(time.UTC))
+	// Steve left the session since
+	assert.Equal(t, players[1].ID, history[1].Sender.ID)
+	assert.Equal(t, "Steve", history[1].Sender.Name)
+}
@@ -43,6 +43,7 @@ func ConnectWithDB(dialector gorm.Dialector) (*gorm.DB, error) {
 	db.AutoMigrate(
 		&Player{},
 		&Session{},
+		&SessionEvent{},
 	)
 
 	return db, err
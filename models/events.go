@@ -2,13 +2,32 @@ package models
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
 )
 
 type EventType string
 
+func (t EventType) MarshalGQL(w io.Writer) {
+	io.WriteString(w, strconv.Quote(string(t)))
+}
+
+func (t *EventType) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("event types must be strings")
+	}
+	*t = EventType(str)
+	return nil
+}
+
 const (
 	JoinEventType  EventType = "JOIN" // TODO: remove once session_test is refactored to not use this
 	ErrorEventType EventType = "ERROR"
+
+	SessionAbortedEventType EventType = "SESSION_ABORTED"
 )
 
 // TODO choose idiomatic names for these interfaces
@@ -18,6 +37,12 @@ type Event interface {
 
 type ServerEvent interface {
 	Event
+
+	// Position of the event in its session's event log, starting at 1. Player events are logged
+	// too so the sequences of the events sent to a player increase but are not contiguous. Events
+	// that have not been sent to a session's player yet have a sequence of 0.
+	Sequence() uint
+	setSequence(uint)
 }
 
 type PlayerEvent interface {
@@ -61,12 +86,21 @@ func NewServerEvent(eventType EventType) ServerEvent {
 
 type basicServerEvent struct {
 	eventType EventType
+	sequence  uint
 }
 
 func (e *basicServerEvent) Type() EventType {
 	return e.eventType
 }
 
+func (e *basicServerEvent) Sequence() uint {
+	return e.sequence
+}
+
+func (e *basicServerEvent) setSequence(sequence uint) {
+	e.sequence = sequence
+}
+
 // Event sent from server in response to a player event that generated an error
 type ErrorEvent struct {
 	ServerEvent
@@ -79,3 +113,34 @@ func NewErrorEvent(err error) *ErrorEvent {
 		Error:       err,
 	}
 }
+
+func (e *ErrorEvent) Message() string {
+	return e.Error.Error()
+}
+
+// Errors don't encode to JSON on their own, the message is what is recorded in the event log
+func (e *ErrorEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Message string
+	}{e.Message()})
+}
+
+// Event sent to the participants of a session that was aborted before its last stage ended
+type SessionAbortedEvent struct {
+	ServerEvent
+	Reason string
+}
+
+func NewSessionAbortedEvent(reason string) *SessionAbortedEvent {
+	return &SessionAbortedEvent{
+		ServerEvent: NewServerEvent(SessionAbortedEventType),
+		Reason:      reason,
+	}
+}
+
+// Event used to present a game's custom server event to clients. The payload is built by the
+// presenter that the game registered for the event's type.
+type GameEvent struct {
+	ServerEvent
+	Payload JSON
+}
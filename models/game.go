@@ -1,5 +1,7 @@
 package models
 
+import "encoding/json"
+
 type Game struct {
 	name         string
 	initialStage StageRunner
@@ -24,3 +26,10 @@ type GameDescriber interface {
 	Name() string
 	InitialStage() StageRunner
 }
+
+// Optional interface for a `GameDescriber` that can restore its stages from the snapshots taken
+// by their `StageSnapshotter`, e.g. to resume a session after the server restarts. The players
+// are those that had joined the session, the snapshot refers to them by ID.
+type StageRestorer interface {
+	RestoreStage(name string, state json.RawMessage, players []*Player) (StageRunner, error)
+}
@@ -17,10 +17,13 @@ func MarshalID(id uint) graphql.Marshaler {
 
 // And the same for the unmarshaler
 func UnmarshalID(v interface{}) (uint, error) {
-	str, ok := v.(string)
-	if !ok {
-		return 0, fmt.Errorf("ids must be strings")
+	str, err := graphql.UnmarshalID(v)
+	if err != nil {
+		return 0, err
 	}
-	i, err := strconv.Atoi(str[1 : len(str)-1])
-	return uint(i), err
+	i, err := strconv.ParseUint(str, 10, 0)
+	if err != nil {
+		return 0, fmt.Errorf("invalid id: %s", str)
+	}
+	return uint(i), nil
 }
@@ -0,0 +1,29 @@
+package models
+
+import "testing"
+
+func TestUnmarshalID(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    uint
+		wantErr bool
+	}{
+		{name: "string", value: "42", want: 42},
+		{name: "int", value: 42, want: 42},
+		{name: "not a number", value: "abc", wantErr: true},
+		{name: "negative", value: "-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := UnmarshalID(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("UnmarshalID() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("UnmarshalID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
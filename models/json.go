@@ -0,0 +1,27 @@
+package models
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// An arbitrary JSON value that is kept in its encoded form until it is decoded into a
+// concrete type, e.g. the payload of a player action
+type JSON json.RawMessage
+
+func (j JSON) MarshalGQL(w io.Writer) {
+	if len(j) == 0 {
+		io.WriteString(w, "null")
+		return
+	}
+	w.Write(j)
+}
+
+func (j *JSON) UnmarshalGQL(v interface{}) error {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	*j = encoded
+	return nil
+}
@@ -1,20 +1,28 @@
 package models
 
 import (
+	"fmt"
+
 	"gorm.io/gorm"
 )
 
+// A participant of a session. The events sent to the player are delivered on ServerEvents as long
+// as there is room in its buffer, events that don't fit are dropped from the channel so that
+// sending never blocks the session's stage. A player who joined a session also keeps them in its
+// history, which is what clients should follow to get every event, see Session.History() and
+// Session.HistoryUpdated().
 type Player struct {
 	*gorm.Model
 
 	Name         string
-	ServerEvents chan ServerEvent `gorm:"-:all"`
+	SessionID    *uint
+	Session      *Session         `gorm:"-:all" json:"-"`
+	ServerEvents chan ServerEvent `gorm:"-:all" json:"-"`
 }
 
 func NewPlayer(db *gorm.DB, name string) (*Player, error) {
 	p := &Player{
-		Name: name,
-		// Session:      *session,
+		Name:         name,
 		ServerEvents: make(chan ServerEvent, ChanBufferSize),
 	}
 	result := db.Create(p)
@@ -24,3 +32,38 @@ func NewPlayer(db *gorm.DB, name string) (*Player, error) {
 
 	return p, nil
 }
+
+func (p *Player) AfterFind(tx *gorm.DB) error {
+	p.ServerEvents = make(chan ServerEvent, ChanBufferSize)
+	return nil
+}
+
+// Send a server event to the player. Events sent to players of a session are numbered in the
+// order they are sent so that clients can tell where they are in the session's event stream, and
+// they are kept in the player's history so that a client that missed some of them can catch up.
+// Sending never blocks the stage, see Player.
+func (p *Player) Send(event ServerEvent) {
+	if p.Session != nil {
+		p.Session.stamp(event)
+		p.Session.remember(p, event)
+	}
+
+	select {
+	case p.ServerEvents <- event:
+	default:
+	}
+}
+
+// Sender of the events the session hands to stages by itself. It is not part of any session and
+// has no channel, events sent to it are dropped so stages can safely reply to any event's sender.
+var systemPlayer = &Player{Name: "system"}
+
+// Find a player by its ID in a list of players
+func FindPlayer(players []*Player, id uint) (*Player, error) {
+	for _, p := range players {
+		if p.ID == id {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("could not find player with id %d", id)
+}
@@ -15,3 +15,102 @@ func TestNewPlayer(t *testing.T) {
 	assert.Equal(t, player.Name, "Mikey")
 	assert.NotNil(t, player.ServerEvents)
 }
+
+func TestPlayer_Send_Sequence(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	session, _ := newSessionWithSeed(db, newGame(), predictableSeed())
+	alice, _ := NewPlayer(db, "Alice")
+	bob, _ := NewPlayer(db, "Bob")
+	session.AddPlayer(alice)
+	session.AddPlayer(bob)
+
+	first := NewServerEvent("FIRST")
+	alice.Send(first)
+	bob.Send(first)
+	second := NewServerEvent("SECOND")
+	bob.Send(second)
+
+	assert.Equal(t, uint(1), first.Sequence())
+	assert.Equal(t, uint(2), second.Sequence())
+	assert.Equal(t, first, <-alice.ServerEvents)
+	assert.Equal(t, first, <-bob.ServerEvents)
+	assert.Equal(t, second, <-bob.ServerEvents)
+}
+
+func TestPlayer_Send_NoSession(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	player, _ := NewPlayer(db, "Mikey")
+	event := NewServerEvent("EVENT")
+	player.Send(event)
+
+	assert.Equal(t, uint(0), event.Sequence())
+	assert.Equal(t, event, <-player.ServerEvents)
+}
+
+func TestPlayer_Send_History(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	session, _ := newSessionWithSeed(db, newGame(), predictableSeed())
+	alice, _ := NewPlayer(db, "Alice")
+	bob, _ := NewPlayer(db, "Bob")
+	session.AddPlayer(alice)
+	session.AddPlayer(bob)
+
+	// More events than fit in the player's channel, sending must not block
+	sent := make([]ServerEvent, PlayerHistorySize+5)
+	for i := range sent {
+		sent[i] = NewServerEvent("EVENT")
+		alice.Send(sent[i])
+	}
+	bob.Send(NewServerEvent("OTHER"))
+
+	history, complete := session.History(alice, sent[9].Sequence())
+	assert.True(t, complete)
+	assert.Equal(t, sent[10:], history)
+
+	_, complete = session.History(alice, sent[3].Sequence())
+	assert.False(t, complete, "The first events should no longer be in the history")
+
+	history, complete = session.History(bob, 0)
+	assert.True(t, complete)
+	assert.Len(t, history, 1)
+}
+
+func TestSession_HistoryUpdated(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	session, _ := newSessionWithSeed(db, newGame(), predictableSeed())
+	alice, _ := NewPlayer(db, "Alice")
+	session.AddPlayer(alice)
+
+	first := session.HistoryUpdated(alice)
+	second := session.HistoryUpdated(alice)
+	select {
+	case <-first:
+		assert.Fail(t, "No event was sent yet")
+	default:
+	}
+
+	event := NewServerEvent("EVENT")
+	alice.Send(event)
+	for _, updated := range []<-chan struct{}{first, second} {
+		select {
+		case <-updated:
+		default:
+			assert.Fail(t, "Every caller should be notified")
+		}
+	}
+	assert.Equal(t, event, <-alice.ServerEvents, "The event should still be delivered on the player's channel")
+
+	select {
+	case <-session.HistoryUpdated(alice):
+		assert.Fail(t, "A new channel should wait for the next event")
+	default:
+	}
+}
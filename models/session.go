@@ -1,8 +1,15 @@
 package models
 
 import (
-	"math/rand"
-	"time"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
 
 	"gorm.io/gorm"
 )
@@ -11,21 +18,110 @@ const (
 	SessionCodeLength = 4
 	SessionCodeMax    = 456976 // 26^4
 	ChanBufferSize    = 100
+	// Number of events kept in each player's history, see Session.History()
+	PlayerHistorySize = 200
 )
 
+// Returned when an event is sent to a session that is no longer handling events, e.g. once it has
+// ended or was suspended
+var ErrSessionEnded = errors.New("the session has ended")
+
 type contextKey string
 
+// Where a session is in its lifecycle
+type SessionStatus string
+
+const (
+	// Players are joining, the session's initial stage is running
+	SessionLobby SessionStatus = "LOBBY"
+	// The initial stage has handed off to the game's other stages
+	SessionRunning SessionStatus = "RUNNING"
+	// The session's last stage has ended
+	SessionFinished SessionStatus = "FINISHED"
+	// The session was stopped before its last stage ended
+	SessionAborted SessionStatus = "ABORTED"
+	// A stage failed unexpectedly
+	SessionCrashed SessionStatus = "CRASHED"
+)
+
+func (s SessionStatus) MarshalGQL(w io.Writer) {
+	io.WriteString(w, strconv.Quote(string(s)))
+}
+
+func (s *SessionStatus) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("session statuses must be strings")
+	}
+	*s = SessionStatus(str)
+	return nil
+}
+
 const SessionKey = contextKey("session")
 
+const (
+	stoppedByAbort int32 = iota + 1
+	stoppedBySuspend
+)
+
 type Session struct {
 	gorm.Model
 
-	Code    string
-	Players []*Player `gorm:"-:all"` // TODO remove once session is no longer aware of players
+	Code   string
+	Status SessionStatus
+	// Same as Code until the session ends. Its unique index keeps live sessions from sharing a
+	// code, even when they are created by different servers.
+	LiveCode *string `gorm:"uniqueIndex" json:"-"`
+
+	// What is needed to restore the session after a restart: the game and the options it was
+	// created with, and a snapshot of its current stage
+	GameKey     string
+	GameOptions JSON
+	StageName   string
+	StageState  JSON
+
+	// Who can find and join the session, see HashPasscode() for the passcode of private sessions
+	Visibility   SessionVisibility
+	PasscodeHash string `json:"-"`
+
+	// Teams kept from the stages that had them as lists of player IDs, see TeamStage. Only used
+	// by the session's event loop once it is running.
+	Teams JSON `json:"-"`
+	teams [][]uint
+
+	Players   []*Player `gorm:"-:all"`
+	playersMu *sync.RWMutex
+	statusMu  *sync.RWMutex
+
+	spectators   map[chan ServerEvent]struct{}
+	spectatorsMu *sync.Mutex
+
+	histories   map[uint]*playerHistory
+	historiesMu *sync.Mutex
+	// Histories only hold the events sent after this sequence, e.g. when the session was resumed
+	historyStart uint
+
+	// Latest public chat messages, see ChatHistory()
+	chat   []*DidChatEvent
+	chatMu *sync.Mutex
+
+	sequence uint64
+	db       *gorm.DB
+	eventLog *eventLogWriter
+	timers   *sessionTimers
 
 	CurrentStage StageRunner               `gorm:"-:all"`
 	ServerEvents map[uint]chan ServerEvent `gorm:"-:all"`
 	PlayerEvents chan PlayerEvent          `gorm:"-:all"`
+
+	stateRequests chan stateRequest
+	done          chan struct{}
+
+	// Cancelled when the session is stopped or its last stage has ended
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Why the session was stopped before its last stage ended, if it was
+	stopReason int32
 }
 
 func (s *Session) AfterCreate(tx *gorm.DB) error {
@@ -42,66 +138,446 @@ func (s *Session) AfterFind(tx *gorm.DB) error {
 // for when a model is retrieved from the database
 // TODO: maybe add a method for mutating these properties to avoid this function
 func initSession(s *Session) {
+	s.playersMu = &sync.RWMutex{}
+	s.statusMu = &sync.RWMutex{}
+	s.spectators = make(map[chan ServerEvent]struct{})
+	s.spectatorsMu = &sync.Mutex{}
+	s.histories = make(map[uint]*playerHistory)
+	s.historiesMu = &sync.Mutex{}
+	s.chatMu = &sync.Mutex{}
+	s.eventLog = &eventLogWriter{}
 	s.ServerEvents = make(map[uint]chan ServerEvent)
 	for _, p := range s.Players {
 		s.ServerEvents[p.ID] = make(chan ServerEvent, ChanBufferSize)
 	}
 
 	s.PlayerEvents = make(chan PlayerEvent, ChanBufferSize)
+	s.stateRequests = make(chan stateRequest)
+	s.done = make(chan struct{})
+	s.ctx, s.cancel = context.WithCancel(context.Background())
 }
 
-func NewSession(db *gorm.DB, initializer GameDescriber) (*Session, error) {
-	return newSessionWithSeed(db, initializer, time.Now().UnixNano)
+// Create a session and start running the game's initial stage. The session's code is picked by
+// the given generator and its timers are driven by the given clock.
+func NewSession(db *gorm.DB, initializer GameDescriber, codes CodeGenerator, clock Clock) (*Session, error) {
+	return createSession(db, initializer, codes, clock)
 }
 
 func newSessionWithSeed(db *gorm.DB, initializer GameDescriber, seed func() int64) (*Session, error) {
+	return createSession(db, initializer, seededCodeGenerator{seed}, SystemClock)
+}
+
+func createSession(db *gorm.DB, initializer GameDescriber, codes CodeGenerator, clock Clock) (*Session, error) {
 	var savedSession *Session
-	for {
-		rand.Seed(seed()) // TODO Use crypto.rand instead!
-		savedSession = &Session{}
-		session := Session{Code: alphaSessionCode(rand.Intn(SessionCodeMax))}
-		result := db.FirstOrCreate(savedSession, &session)
-		if result.Error != nil {
-			return nil, result.Error
-		} else if result.RowsAffected == 1 {
-			break
+	for attempt := 0; savedSession == nil; attempt++ {
+		if attempt == MaxSessionCodeAttempts {
+			return nil, ErrCodeSpaceExhausted
+		}
+		code, err := codes.Generate()
+		if err != nil {
+			return nil, err
+		}
+		if savedSession, err = createSessionWithCode(db, code); err != nil {
+			return nil, err
 		}
 	}
 
 	// Start the session in a go routine
+	savedSession.db = db
+	savedSession.timers = newSessionTimers(savedSession, clock)
 	savedSession.CurrentStage = initializer.InitialStage()
-
-	// TODO - wrap this go routine in a lambda to manage the stage transitions
-	// .. also, make that threadsafe
 	go startSession(savedSession)
 
 	return savedSession, nil
 }
 
-// This is the main game loop which is executed as a subroutine. It starts running
-// the initial StageRunner and transitions to others as the runner processes events.
-func startSession(session *Session) {
-	currentStage := session.CurrentStage
-	for {
-		currentStage = currentStage.Run(session.PlayerEvents)
+// Save a new session unless its code is used by a live session, in which case nil is returned.
+// The codes of sessions that have ended can be reused.
+func createSessionWithCode(db *gorm.DB, code string) (*Session, error) {
+	if used, err := liveCodeUsed(db, code); used || err != nil {
+		return nil, err
+	}
 
-		// TODO: how does the game end?
-		if currentStage == nil {
-			break
+	session := &Session{Code: code, LiveCode: &code, Status: SessionLobby, Visibility: SessionPublic}
+	if err := db.Create(session).Error; err != nil {
+		// Another session may have taken the code since it was checked, the unique index on live
+		// codes rejects the session then. Drivers report it differently so the code is checked again.
+		if used, _ := liveCodeUsed(db, code); used {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return session, nil
+}
+
+func liveCodeUsed(db *gorm.DB, code string) (bool, error) {
+	var live int64
+	err := db.Model(&Session{}).Where("live_code = ? OR (code = ? AND status IN ?)", code, code, liveStatuses).Count(&live).Error
+	return live > 0, err
+}
+
+// Statuses of the sessions that have not ended
+var liveStatuses = []SessionStatus{SessionLobby, SessionRunning}
+
+func (s SessionStatus) isLive() bool {
+	return s == SessionLobby || s == SessionRunning
+}
+
+// Sessions that had not ended when they were last saved
+func LiveSessions(db *gorm.DB) ([]*Session, error) {
+	var sessions []*Session
+	result := db.Where("status IN ?", liveStatuses).Find(&sessions)
+	return sessions, result.Error
+}
+
+// Resume a session loaded from the database, e.g. after the server restarts. The session's
+// players are reloaded and its current stage is restored from its last snapshot. A session that
+// cannot be restored is marked as aborted.
+func ResumeSession(db *gorm.DB, session *Session, describer GameDescriber, clock Clock) error {
+	session.db = db
+	session.timers = newSessionTimers(session, clock)
+	if err := session.resume(describer); err != nil {
+		session.setStatus(SessionAborted)
+		return fmt.Errorf(`failed to resume session "%s": %w`, session.Code, err)
+	}
+
+	go startSession(session)
+	return nil
+}
+
+// Mark a session loaded from the database as aborted, e.g. when its game is no longer available
+func AbortSession(db *gorm.DB, session *Session) {
+	session.db = db
+	session.setStatus(SessionAborted)
+}
+
+func (s *Session) resume(describer GameDescriber) error {
+	var players []*Player
+	if err := s.db.Where("session_id = ?", s.ID).Order("id").Find(&players).Error; err != nil {
+		return err
+	}
+	for _, p := range players {
+		p.Session = s
+	}
+	s.Players = players
+
+	var sequence uint64
+	err := s.db.Model(&SessionEvent{}).Where("session_id = ?", s.ID).Select("COALESCE(MAX(sequence), 0)").Scan(&sequence).Error
+	if err != nil {
+		return err
+	}
+	s.sequence = sequence
+	s.historyStart = uint(sequence)
+	if err := s.restoreChat(); err != nil {
+		return err
+	}
+
+	if err := s.restoreTeams(); err != nil {
+		return err
+	}
+
+	if s.StageName == "" {
+		if s.Status != SessionLobby {
+			return fmt.Errorf("the running stage did not save a snapshot")
 		}
+		s.CurrentStage = describer.InitialStage()
+		return nil
+	}
+
+	restorer, ok := describer.(StageRestorer)
+	if !ok {
+		return fmt.Errorf("game %s cannot restore its stages", describer.Name())
 	}
+	stage, err := restorer.RestoreStage(s.StageName, json.RawMessage(s.StageState), players)
+	if err != nil {
+		return err
+	}
+	s.CurrentStage = stage
+	return nil
 }
 
-func (s *Session) HandlePlayerEvent(event PlayerEvent) {
-	s.PlayerEvents <- event
+// Pass an event on to the session's stage. An error is returned if the session is no longer
+// handling events, or if the event's context is done while waiting for the session to take it.
+func (s *Session) HandlePlayerEvent(event PlayerEvent) error {
+	ctx := event.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	select {
+	case <-s.done:
+		return ErrSessionEnded
+	default:
+	}
+	// The event is taken whenever there is room for it, even if its context is done already
+	select {
+	case s.PlayerEvents <- event:
+		return nil
+	default:
+	}
+	select {
+	case s.PlayerEvents <- event:
+		return nil
+	case <-s.done:
+		return ErrSessionEnded
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func alphaSessionCode(code int) string {
-	encoded := ""
-	for len(encoded) < 4 {
-		num := code % 26
-		encoded = string(rune('A'+num)) + encoded
-		code /= 26
+// Returns a channel that is closed once the session's last stage has ended.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Stop the session before its last stage ends. The running stage's context is cancelled and
+// the session's players and spectators are notified with the reason.
+func (s *Session) Abort(reason string) error {
+	if err := s.stop(stoppedByAbort); err != nil {
+		return err
+	}
+	s.setStatus(SessionAborted)
+	// Participants are notified before the event loop stops, spectators are disconnected once it
+	// has stopped
+	s.Notify(NewSessionAbortedEvent(reason))
+	s.cancel()
+	return nil
+}
+
+// Stop the session's event loop without ending the session, e.g. when the server shuts down. The
+// session keeps its status and the snapshot saved after the last event its stage handled, so it
+// is resumed by ResumeSession once the server restarts. Events that were not handled yet are lost.
+func (s *Session) Suspend() error {
+	if err := s.stop(stoppedBySuspend); err != nil {
+		return err
+	}
+	s.cancel()
+	return nil
+}
+
+// Record why the session is being stopped, only the first reason is kept
+func (s *Session) stop(reason int32) error {
+	select {
+	case <-s.done:
+		return fmt.Errorf(`session "%s" has already ended`, s.Code)
+	default:
+	}
+	if !atomic.CompareAndSwapInt32(&s.stopReason, 0, reason) {
+		return fmt.Errorf(`session "%s" was already stopped`, s.Code)
+	}
+	return nil
+}
+
+// Send an event to all of the session's participants, its players and spectators
+func (s *Session) Notify(event ServerEvent) {
+	for _, p := range s.players() {
+		p.Send(event)
+	}
+	s.Publish(event)
+}
+
+// The session's status, safe to call while the session is running.
+func (s *Session) CurrentStatus() SessionStatus {
+	s.statusMu.RLock()
+	defer s.statusMu.RUnlock()
+	return s.Status
+}
+
+// Update the session's status and persist it. This is called from the session's event loop where
+// there is no one to report an error to, so failing to persist the status is only logged.
+func (s *Session) setStatus(status SessionStatus) {
+	updates := map[string]interface{}{"status": status}
+	s.statusMu.Lock()
+	s.Status = status
+	if !status.isLive() {
+		// The code can be given to a new session
+		s.LiveCode = nil
+		updates["live_code"] = nil
+	}
+	s.statusMu.Unlock()
+
+	if s.db == nil {
+		return
+	}
+	// Saved through a separate model since gorm writes the updated values back to the model, which
+	// would race with readers of the status
+	err := s.db.Model(&Session{}).Where("id = ?", s.ID).Updates(updates).Error
+	if err != nil {
+		log.Printf(`Failed to save status %s of session "%s": %s`, status, s.Code, err)
+	}
+}
+
+// The players participating in the session, safe to call while the session is running.
+func (s *Session) CurrentPlayers() []*Player {
+	return s.players()
+}
+
+// Players participating in the session
+func (s *Session) players() []*Player {
+	s.playersMu.RLock()
+	defer s.playersMu.RUnlock()
+
+	players := make([]*Player, len(s.Players))
+	copy(players, s.Players)
+	return players
+}
+
+// Assign the session's next sequence number to an event unless it already has one, which is
+// the case when the same event is sent to multiple players. The event is recorded in the session's
+// log the first time it is stamped.
+func (s *Session) stamp(event ServerEvent) {
+	if event.Sequence() == 0 {
+		sequence := s.nextSequence()
+		event.setSequence(sequence)
+		if _, ok := event.(*ChatHistoryEvent); ok {
+			// It only repeats messages that are already in the log
+			return
+		}
+		s.recordEvent(sequence, ServerEventSource, event)
+	}
+}
+
+// Bounded list of the latest events sent to a player
+type playerHistory struct {
+	events []ServerEvent
+	// Sequence of the latest event that no longer fits in the history
+	floor uint
+	// Closed once the next event is added to the history, see HistoryUpdated()
+	updated chan struct{}
+}
+
+func (s *Session) remember(player *Player, event ServerEvent) {
+	if player.Model == nil {
+		return
+	}
+
+	s.historiesMu.Lock()
+	defer s.historiesMu.Unlock()
+
+	history, ok := s.histories[player.ID]
+	if !ok {
+		history = &playerHistory{floor: s.historyStart}
+		s.histories[player.ID] = history
+	}
+	if len(history.events) == PlayerHistorySize {
+		history.floor = history.events[0].Sequence()
+		history.events = history.events[1:]
+	}
+	history.events = append(history.events, event)
+	if history.updated != nil {
+		close(history.updated)
+		history.updated = nil
+	}
+}
+
+// Returns a channel that is closed once the next event is sent to a player. Every caller is
+// notified, so any number of clients can follow the player's history: get the channel, read the
+// history, then wait on the channel before reading the history again.
+func (s *Session) HistoryUpdated(player *Player) <-chan struct{} {
+	s.historiesMu.Lock()
+	defer s.historiesMu.Unlock()
+
+	history, ok := s.histories[player.ID]
+	if !ok {
+		history = &playerHistory{floor: s.historyStart}
+		s.histories[player.ID] = history
+	}
+	if history.updated == nil {
+		history.updated = make(chan struct{})
+	}
+	return history.updated
+}
+
+// Events sent to a player after the given sequence number. The history is bounded, false is
+// returned when some of the events that followed the sequence number are no longer in it.
+func (s *Session) History(player *Player, afterSequence uint) ([]ServerEvent, bool) {
+	s.historiesMu.Lock()
+	defer s.historiesMu.Unlock()
+
+	history, ok := s.histories[player.ID]
+	if !ok {
+		return nil, afterSequence >= s.historyStart
+	}
+
+	var events []ServerEvent
+	for _, event := range history.events {
+		if event.Sequence() > afterSequence {
+			events = append(events, event)
+		}
+	}
+	return events, afterSequence >= history.floor
+}
+
+// Sequence number of the session's latest logged event
+func (s *Session) lastSequence() uint {
+	return uint(atomic.LoadUint64(&s.sequence))
+}
+
+// Sequence numbers are shared by the player and server events of the session's log
+func (s *Session) nextSequence() uint {
+	return uint(atomic.AddUint64(&s.sequence, 1))
+}
+
+// Add a player to the list of players that are participating in the session, e.g. by a lobby
+// stage as it accepts the player. The player is sent the latest public chat messages so that it
+// can catch up on the conversation.
+func (s *Session) AddPlayer(player *Player) {
+	s.addPlayer(player)
+
+	if messages := s.ChatHistory(); len(messages) > 0 {
+		player.Send(NewChatHistoryEvent(messages))
+	}
+}
+
+func (s *Session) addPlayer(player *Player) {
+	s.playersMu.Lock()
+	defer s.playersMu.Unlock()
+
+	// Players usually reference their session already, e.g. when their join request was sent, and
+	// the session's stage may be reading it concurrently
+	if player.Session != s {
+		player.Session = s
+	}
+	s.Players = append(s.Players, player)
+
+	if s.db != nil && player.Model != nil {
+		err := s.db.Model(&Player{Model: &gorm.Model{ID: player.ID}}).Update("session_id", s.ID).Error
+		if err != nil {
+			log.Printf(`Failed to save player %d of session "%s": %s`, player.ID, s.Code, err)
+		}
+	}
+}
+
+// Remove a player from the list of players that are participating in the session, e.g. when it is
+// kicked out of the lobby. The player can still receive the events that are sent to it.
+func (s *Session) RemovePlayer(player *Player) {
+	s.playersMu.Lock()
+	defer s.playersMu.Unlock()
+
+	for i, p := range s.Players {
+		if p == player {
+			s.Players = append(s.Players[:i], s.Players[i+1:]...)
+			break
+		}
+	}
+
+	if s.db != nil && player.Model != nil {
+		err := s.db.Model(&Player{Model: &gorm.Model{ID: player.ID}}).Update("session_id", nil).Error
+		if err != nil {
+			log.Printf(`Failed to remove player %d from session "%s": %s`, player.ID, s.Code, err)
+		}
+	}
+}
+
+// Lookup a player participating in the session by its ID.
+func (s *Session) PlayerForID(id uint) (*Player, error) {
+	s.playersMu.RLock()
+	defer s.playersMu.RUnlock()
+
+	for _, p := range s.Players {
+		if p.ID == id {
+			return p, nil
+		}
 	}
-	return encoded
+	return nil, fmt.Errorf(`could not find player with id %d in session "%s"`, id, s.Code)
 }
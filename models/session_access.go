@@ -0,0 +1,69 @@
+package models
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Whether a session is listed for anyone to join
+type SessionVisibility string
+
+const (
+	// Listed by the server while players can join it
+	SessionPublic SessionVisibility = "PUBLIC"
+	// Only joined by players who were given its code, and its passcode if it has one, or an invite
+	SessionPrivate SessionVisibility = "PRIVATE"
+)
+
+func (v SessionVisibility) MarshalGQL(w io.Writer) {
+	io.WriteString(w, strconv.Quote(string(v)))
+}
+
+func (v *SessionVisibility) UnmarshalGQL(value interface{}) error {
+	str, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("session visibilities must be strings")
+	}
+	*v = SessionVisibility(str)
+	return nil
+}
+
+// Optional interface for a `StageRunner` that players join, e.g. a lobby. Sessions whose stage
+// doesn't implement it accept players as long as they are in the lobby. AcceptingPlayers is
+// called by the session while the stage is not processing an event.
+type LobbyStage interface {
+	// Whether a player could join now, e.g. false when the lobby is full or locked
+	AcceptingPlayers() bool
+}
+
+// Whether the session is listed for anyone to join, sessions saved before visibilities existed
+// are public
+func (s *Session) IsPublic() bool {
+	return s.Visibility != SessionPrivate
+}
+
+// Whether a passcode must be given to join the session
+func (s *Session) HasPasscode() bool {
+	return s.PasscodeHash != ""
+}
+
+// Hash of a passcode, the passcode itself is never saved. Hashing is slow on purpose, which makes
+// guessing a passcode from its hash impractical.
+func HashPasscode(passcode string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(passcode), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Check a passcode given to join the session, any passcode is accepted when it has none
+func (s *Session) CheckPasscode(passcode string) bool {
+	if !s.HasPasscode() {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(s.PasscodeHash), []byte(passcode)) == nil
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSession_CheckPasscode(t *testing.T) {
+	hash, err := HashPasscode("hunter2")
+	require.Nil(t, err)
+	other, _ := HashPasscode("hunter2")
+	assert.NotEqual(t, hash, other, "Passcodes should be salted")
+
+	session := &Session{Visibility: SessionPrivate, PasscodeHash: hash}
+	assert.True(t, session.CheckPasscode("hunter2"))
+	assert.False(t, session.CheckPasscode("Hunter2"))
+	assert.False(t, session.CheckPasscode(""))
+
+	open := &Session{Visibility: SessionPrivate}
+	assert.False(t, open.HasPasscode())
+	assert.True(t, open.CheckPasscode(""), "Any passcode should be accepted when the session has none")
+	assert.True(t, open.CheckPasscode("anything"))
+}
+
+func TestSession_IsPublic(t *testing.T) {
+	assert.True(t, (&Session{Visibility: SessionPublic}).IsPublic())
+	assert.True(t, (&Session{}).IsPublic(), "Sessions saved before visibilities existed are public")
+	assert.False(t, (&Session{Visibility: SessionPrivate}).IsPublic())
+}
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	mathrand "math/rand"
+	"strings"
+)
+
+const (
+	// Number of codes tried for a new session before giving up, see ErrCodeSpaceExhausted
+	MaxSessionCodeAttempts = 20
+
+	LettersCodeAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	// Letters without those that are easily mistaken for others when read aloud or handwritten
+	UnambiguousCodeAlphabet = "ABCDEFGHJKMNPQRTUVWXYZ"
+	DigitsCodeAlphabet      = "0123456789"
+)
+
+// Returned when no unused code was found for a new session, most codes of the generator are
+// taken by live sessions
+var ErrCodeSpaceExhausted = fmt.Errorf("could not find an unused session code after %d attempts, too many sessions are live", MaxSessionCodeAttempts)
+
+// Generates the codes that players enter to find a session. Codes only need to be unique among
+// live sessions, a code that is taken is discarded and another one is generated.
+type CodeGenerator interface {
+	Generate() (string, error)
+}
+
+// Letter combinations that are never used in codes
+var DefaultCodeBlocklist = []string{
+	"ANAL", "ANUS", "ARSE", "ASS", "CUM", "COCK", "CUNT", "DICK", "DIE", "FAG", "FUCK", "JIZZ",
+	"KIKE", "KILL", "KKK", "NAZI", "NIG", "PISS", "PORN", "RAPE", "SEX", "SHIT", "SLUT", "SPIC",
+	"TIT", "TWAT", "WANK", "WTF",
+}
+
+// Generates codes of random characters picked from an alphabet with a secure source of
+// randomness. The zero value generates codes of SessionCodeLength letters.
+type RandomCodeGenerator struct {
+	Length   int
+	Alphabet string
+	// Codes that contain any of these strings are discarded
+	Blocklist []string
+}
+
+// Generator used when none is configured: four letters without offensive combinations
+var DefaultCodeGenerator = &RandomCodeGenerator{
+	Length:    SessionCodeLength,
+	Alphabet:  LettersCodeAlphabet,
+	Blocklist: DefaultCodeBlocklist,
+}
+
+func (g *RandomCodeGenerator) Generate() (string, error) {
+	length, alphabet := g.Length, []rune(g.Alphabet)
+	if length == 0 {
+		length = SessionCodeLength
+	}
+	if len(alphabet) == 0 {
+		alphabet = []rune(LettersCodeAlphabet)
+	}
+	if length < 0 {
+		return "", fmt.Errorf("invalid session code length: %d", length)
+	}
+
+	max := big.NewInt(int64(len(alphabet)))
+	for attempt := 0; attempt < MaxSessionCodeAttempts; attempt++ {
+		var code strings.Builder
+		for i := 0; i < length; i++ {
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return "", err
+			}
+			code.WriteRune(alphabet[n.Int64()])
+		}
+		if !g.blocked(code.String()) {
+			return code.String(), nil
+		}
+	}
+	return "", errors.New("could not generate a session code that is not blocked")
+}
+
+func (g *RandomCodeGenerator) blocked(code string) bool {
+	upper := strings.ToUpper(code)
+	for _, word := range g.Blocklist {
+		if strings.Contains(upper, strings.ToUpper(word)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Predictable codes for tests, each code is derived from the next seed
+type seededCodeGenerator struct {
+	seed func() int64
+}
+
+func (g seededCodeGenerator) Generate() (string, error) {
+	return alphaSessionCode(mathrand.New(mathrand.NewSource(g.seed())).Intn(SessionCodeMax)), nil
+}
+
+func alphaSessionCode(code int) string {
+	encoded := ""
+	for len(encoded) < 4 {
+		num := code % 26
+		encoded = string(rune('A'+num)) + encoded
+		code /= 26
+	}
+	return encoded
+}
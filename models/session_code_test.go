@@ -0,0 +1,92 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Generator that always returns the same code
+type fixedCodeGenerator string
+
+func (g fixedCodeGenerator) Generate() (string, error) {
+	return string(g), nil
+}
+
+func TestRandomCodeGenerator(t *testing.T) {
+	generator := &RandomCodeGenerator{Length: 6, Alphabet: DigitsCodeAlphabet}
+	for i := 0; i < 100; i++ {
+		code, err := generator.Generate()
+		require.Nil(t, err)
+		assert.Len(t, code, 6)
+		assert.Empty(t, strings.Trim(code, DigitsCodeAlphabet), "Code %s has characters outside of the alphabet", code)
+	}
+}
+
+func TestRandomCodeGenerator_Default(t *testing.T) {
+	code, err := (&RandomCodeGenerator{}).Generate()
+	require.Nil(t, err)
+	assert.Len(t, code, SessionCodeLength)
+	assert.Empty(t, strings.Trim(code, LettersCodeAlphabet))
+}
+
+func TestRandomCodeGenerator_Blocklist(t *testing.T) {
+	// One of the two codes is blocked, the other one is practically certain to come up within the
+	// allowed attempts
+	generator := &RandomCodeGenerator{Length: 1, Alphabet: "AB", Blocklist: []string{"a"}}
+	code, err := generator.Generate()
+	require.Nil(t, err)
+	assert.Equal(t, "B", code, "Blocked combinations should be compared regardless of case")
+
+	generator.Blocklist = []string{"A", "B"}
+	_, err = generator.Generate()
+	assert.ErrorContains(t, err, "could not generate a session code that is not blocked")
+}
+
+func TestNewSession_CodeReused(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	ended, _ := NewSession(db, newGame(), fixedCodeGenerator("ABCD"), SystemClock)
+	<-ended.Done()
+
+	session, err := NewSession(db, NewGame("TestGame", &countingStage{}), fixedCodeGenerator("ABCD"), SystemClock)
+	require.Nil(t, err)
+	assert.Equal(t, "ABCD", session.Code, "Codes of ended sessions should be reused")
+
+	_, err = NewSession(db, newGame(), fixedCodeGenerator("ABCD"), SystemClock)
+	assert.ErrorIs(t, err, ErrCodeSpaceExhausted)
+}
+
+func TestCreateSessionWithCode_Concurrent(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	results := make(chan *Session)
+	for i := 0; i < 10; i++ {
+		go func() {
+			session, err := createSessionWithCode(db, "ABCD")
+			assert.Nil(t, err)
+			results <- session
+		}()
+	}
+	var created []*Session
+	for i := 0; i < 10; i++ {
+		if session := <-results; session != nil {
+			created = append(created, session)
+		}
+	}
+	require.Len(t, created, 1, "Only one live session should get the code")
+
+	duplicate := "ABCD"
+	err := db.Create(&Session{Code: "ABCD", LiveCode: &duplicate, Status: SessionLobby}).Error
+	assert.NotNil(t, err, "The database should reject a second live session with the same code")
+
+	created[0].db = db
+	created[0].setStatus(SessionFinished)
+	session, err := createSessionWithCode(db, "ABCD")
+	require.Nil(t, err)
+	assert.NotNil(t, session, "The code should be released once the session has ended")
+}
@@ -0,0 +1,162 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Whether a logged event was sent by a player, by the server or by the session itself
+type EventSource string
+
+const (
+	PlayerEventSource EventSource = "PLAYER"
+	ServerEventSource EventSource = "SERVER"
+	// Events without a player behind them that stages receive, e.g. timer events
+	SystemEventSource EventSource = "SYSTEM"
+)
+
+// Maximum number of log entries inserted by a single statement
+const eventLogBatchSize = 100
+
+// Entry of a session's event log. Every player event accepted by a stage and every server event
+// sent to the session's players is recorded in the order of its sequence number.
+type SessionEvent struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+
+	SessionID uint `gorm:"uniqueIndex:idx_session_events_sequence"`
+	Sequence  uint `gorm:"uniqueIndex:idx_session_events_sequence"`
+	Source    EventSource
+	Type      EventType
+	SenderID  *uint
+	Payload   JSON
+}
+
+// Build the log entry of an event. The payload holds the event's exported fields.
+func newSessionEvent(session *Session, sequence uint, source EventSource, event Event) (*SessionEvent, error) {
+	payload, err := eventPayload(event)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &SessionEvent{
+		SessionID: session.ID,
+		Sequence:  sequence,
+		Source:    source,
+		Type:      event.Type(),
+		Payload:   payload,
+	}
+	if playerEvent, ok := event.(PlayerEvent); ok && playerEvent.Sender() != nil && playerEvent.Sender().Model != nil {
+		entry.SenderID = &playerEvent.Sender().ID
+	}
+	return entry, nil
+}
+
+// Encode an event's fields, leaving out the embedded PlayerEvent or ServerEvent that only
+// describe the event's type and sequence which are logged in their own columns.
+func eventPayload(event Event) (JSON, error) {
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		// Events that encode to something other than an object are logged as is
+		return encoded, nil
+	}
+	delete(fields, "PlayerEvent")
+	delete(fields, "ServerEvent")
+	return json.Marshal(fields)
+}
+
+// Where the log entries of a session wait to be saved. Entries are saved in batches by a go
+// routine that only runs while there are entries waiting, so that the session's event loop never
+// waits on the database.
+type eventLogWriter struct {
+	mu      sync.Mutex
+	pending []*SessionEvent
+	writing bool
+
+	// Held while a batch is saved so that a flush returns once everything before it was saved
+	flushMu sync.Mutex
+}
+
+// Record an event in the session's log. Events are recorded from the session's event loop and
+// from stages where there is no one to report an error to, so failures are only logged.
+func (s *Session) record(entry *SessionEvent) {
+	if s.db == nil {
+		return
+	}
+
+	s.eventLog.mu.Lock()
+	defer s.eventLog.mu.Unlock()
+	s.eventLog.pending = append(s.eventLog.pending, entry)
+	if !s.eventLog.writing {
+		s.eventLog.writing = true
+		go s.writeEventLog()
+	}
+}
+
+func (s *Session) writeEventLog() {
+	for {
+		s.flushEventLog()
+
+		s.eventLog.mu.Lock()
+		if len(s.eventLog.pending) == 0 {
+			s.eventLog.writing = false
+			s.eventLog.mu.Unlock()
+			return
+		}
+		s.eventLog.mu.Unlock()
+	}
+}
+
+// Save the log entries that are waiting, returns once the entries recorded before the call are saved
+func (s *Session) flushEventLog() {
+	s.eventLog.flushMu.Lock()
+	defer s.eventLog.flushMu.Unlock()
+
+	s.eventLog.mu.Lock()
+	batch := s.eventLog.pending
+	s.eventLog.pending = nil
+	s.eventLog.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+	// Player events are numbered before the events they cause but recorded once they are accepted
+	sort.Slice(batch, func(i, j int) bool { return batch[i].Sequence < batch[j].Sequence })
+	if err := s.db.CreateInBatches(batch, eventLogBatchSize).Error; err != nil {
+		log.Printf(`Failed to record events %d to %d in session "%s": %s`, batch[0].Sequence, batch[len(batch)-1].Sequence, s.Code, err)
+	}
+}
+
+// Build and record the log entry of an event
+func (s *Session) recordEvent(sequence uint, source EventSource, event Event) {
+	if s.db == nil {
+		return
+	}
+	entry, err := newSessionEvent(s, sequence, source, event)
+	if err != nil {
+		log.Printf(`Failed to encode event %d of type %s in session "%s": %s`, sequence, event.Type(), s.Code, err)
+		return
+	}
+	s.record(entry)
+}
+
+// The session's recorded events ordered by sequence number
+func (s *Session) EventLog() ([]SessionEvent, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf(`session "%s" does not have an event log`, s.Code)
+	}
+
+	s.flushEventLog()
+	var events []SessionEvent
+	result := s.db.Where("session_id = ?", s.ID).Order("sequence").Find(&events)
+	return events, result.Error
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSession_EventLog(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	session, _ := newSessionWithSeed(db, NewGame("TestGame", &countingStage{}), predictableSeed())
+	player, _ := NewPlayer(db, "Mikey")
+	session.AddPlayer(player)
+
+	session.HandlePlayerEvent(NewPlayerEvent(context.Background(), "COUNT", player))
+	session.HandlePlayerEvent(NewPlayerEvent(context.Background(), "COUNT", player))
+	_, err := session.State(context.Background())
+	require.Nil(t, err)
+	player.Send(NewErrorEvent(fmt.Errorf("boom")))
+
+	events, err := session.EventLog()
+	require.Nil(t, err)
+	require.Len(t, events, 3)
+
+	for i, event := range events[:2] {
+		assert.Equal(t, session.ID, event.SessionID)
+		assert.Equal(t, uint(i+1), event.Sequence)
+		assert.Equal(t, PlayerEventSource, event.Source)
+		assert.Equal(t, EventType("COUNT"), event.Type)
+		require.NotNil(t, event.SenderID)
+		assert.Equal(t, player.ID, *event.SenderID)
+		assert.JSONEq(t, `{}`, string(event.Payload))
+	}
+
+	serverEvent := events[2]
+	assert.Equal(t, uint(3), serverEvent.Sequence)
+	assert.Equal(t, ServerEventSource, serverEvent.Source)
+	assert.Equal(t, ErrorEventType, serverEvent.Type)
+	assert.Nil(t, serverEvent.SenderID)
+	assert.JSONEq(t, `{"Message": "boom"}`, string(serverEvent.Payload))
+}
+
+func TestSession_EventLog_SentOnce(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	session, _ := newSessionWithSeed(db, newGame(), predictableSeed())
+	alice, _ := NewPlayer(db, "Alice")
+	bob, _ := NewPlayer(db, "Bob")
+	session.AddPlayer(alice)
+	session.AddPlayer(bob)
+
+	event := NewServerEvent("HELLO")
+	alice.Send(event)
+	bob.Send(event)
+
+	events, err := session.EventLog()
+	require.Nil(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, event.Sequence(), events[0].Sequence)
+}
+
+func TestSession_EventLog_Timer(t *testing.T) {
+	session, stage, clock, cleanup := newTimedSession(t, map[string]time.Duration{"turn": time.Minute})
+	defer cleanup()
+
+	clock.Advance(time.Minute)
+	assert.Equal(t, "turn", waitForTimer(t, stage))
+
+	events, err := session.EventLog()
+	require.Nil(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, SystemEventSource, events[0].Source)
+	assert.Equal(t, TimerEventType, events[0].Type)
+	assert.Nil(t, events[0].SenderID)
+	assert.JSONEq(t, `{"Name": "turn"}`, string(events[0].Payload))
+}
+
+func TestSession_EventLog_Done(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	session, _ := newSessionWithSeed(db, NewGame("TestGame", &countingStage{}), predictableSeed())
+	player, _ := NewPlayer(db, "Mikey")
+	session.AddPlayer(player)
+	for i := 0; i < eventLogBatchSize+1; i++ {
+		session.HandlePlayerEvent(NewPlayerEvent(context.Background(), "COUNT", player))
+	}
+	_, err := session.State(context.Background())
+	require.Nil(t, err)
+	require.Nil(t, session.Suspend())
+	waitForDone(t, session)
+
+	// The log is saved by the time the session is done, without going through EventLog()
+	var logged int64
+	require.Nil(t, db.Model(&SessionEvent{}).Where("session_id = ?", session.ID).Count(&logged).Error)
+	assert.Equal(t, int64(eventLogBatchSize+1), logged)
+}
+
+func Test_eventPayload(t *testing.T) {
+	player := &Player{Name: "Mikey"}
+	event := &struct {
+		PlayerEvent
+		Column int
+	}{
+		PlayerEvent: NewPlayerEvent(context.Background(), "DROP", player),
+		Column:      3,
+	}
+
+	payload, err := eventPayload(event)
+	require.Nil(t, err)
+	assert.JSONEq(t, `{"Column": 3}`, string(payload))
+}
@@ -0,0 +1,317 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"sync/atomic"
+)
+
+// Snapshot of what is going on in a session
+type SessionState struct {
+	Stage   string
+	Players []*Player
+	State   JSON
+	// Latest public chat messages
+	Chat []*DidChatEvent
+	// Whether players can join the session, see LobbyStage
+	AcceptingPlayers bool
+
+	// Sequence number of the last event logged before the snapshot was taken
+	sequence uint
+}
+
+type stateRequest struct {
+	reply chan<- stateReply
+	// When set, the channel is registered as a spectator right after the snapshot is taken
+	spectator chan ServerEvent
+}
+
+type stateReply struct {
+	state *SessionState
+	err   error
+}
+
+// Event forwarded to a stage to find out when it has finished processing the previous event, the
+// stage is known to be idle once it accepts it. Stages ignore it like any other event they don't
+// recognize. It is sent by the session itself, so replies sent to its sender go nowhere.
+var syncEvent = NewPlayerEvent(context.Background(), "SYNC", systemPlayer)
+
+// This is the main game loop which is executed as a subroutine. It starts running
+// the initial StageRunner and transitions to others as the runner processes events.
+func startSession(session *Session) {
+	defer close(session.done)
+	// The whole log is saved by the time the session is done
+	defer session.flushEventLog()
+	defer session.cancel()
+	defer session.supervise()
+
+	var pending PlayerEvent
+	var err error
+	for session.CurrentStage != nil && err == nil {
+		stage := session.CurrentStage
+		session.CurrentStage, pending, err = session.runStage(stage, pending)
+		// Timers belong to the stage that scheduled them, its teams outlast it
+		session.timers.cancelAll()
+		session.keepTeams(stage)
+		if session.CurrentStage != nil && session.CurrentStatus() == SessionLobby {
+			session.setStatus(SessionRunning)
+		}
+	}
+
+	stopReason := atomic.LoadInt32(&session.stopReason)
+	switch {
+	case stopReason == stoppedBySuspend:
+		// Left as is so that the session is resumed when the server restarts
+	case stopReason == stoppedByAbort:
+		// Participants were notified by Abort()
+		session.setStatus(SessionAborted)
+	case err != nil:
+		session.crash(err)
+	default:
+		session.setStatus(SessionFinished)
+	}
+}
+
+// Run a single stage until it returns the next one. The stage runs in its own go routine and
+// receives events one at a time through an unbuffered channel, so whenever the stage accepts
+// an event it is guaranteed to be done with the previous one. This is what lets the session
+// read the stage's state between two events without racing with it.
+//
+// An event that was not accepted before the stage ended is returned so that it can be handed
+// to the next stage. The stage's event channel is closed once it ends or the session is aborted.
+func (s *Session) runStage(stage StageRunner, pending PlayerEvent) (StageRunner, PlayerEvent, error) {
+	// The stage has not started running yet so its state can be read safely
+	s.checkpoint(stage)
+	if timed, ok := stage.(TimedStage); ok {
+		timed.UseTimers(s.timers)
+	}
+
+	events := make(chan PlayerEvent)
+	defer close(events)
+
+	// The stage's error, if any, is set before the next stage is sent
+	var stageErr error
+	next := make(chan StageRunner, 1)
+	// Set once the next stage was received, the stage's error must not be read before then
+	ended := false
+	result := func() error {
+		if !ended {
+			// The session was stopped while the stage was still running
+			return nil
+		}
+		return stageErr
+	}
+	go func() {
+		var nextStage StageRunner
+		defer func() {
+			if r := recover(); r != nil {
+				nextStage, stageErr = nil, s.recovered(r)
+			}
+			next <- nextStage
+		}()
+
+		nextStage, err := AdaptStageRunner(stage).RunContext(s.ctx, events)
+		if err != nil && s.ctx.Err() == nil {
+			stageErr = err
+		}
+	}()
+
+	// Hand an event to the stage, or return false if the stage ended before accepting it. Events
+	// are recorded in the session's log once they are accepted.
+	forward := func(event PlayerEvent) (StageRunner, bool) {
+		entry := s.logEntry(event)
+		select {
+		case events <- event:
+			if entry != nil {
+				s.record(entry)
+			}
+			return nil, true
+		case nextStage := <-next:
+			ended = true
+			return nextStage, false
+		case <-s.ctx.Done():
+			return nil, false
+		}
+	}
+
+	// Hand an event to the stage and save the stage's state once the stage is done with it. If the
+	// stage ended, the next stage is returned along with the event if it was not accepted.
+	handle := func(event PlayerEvent) (StageRunner, PlayerEvent, bool) {
+		if timerEvent, ok := event.(*TimerEvent); ok && !s.timers.expire(timerEvent) {
+			// The timer was cancelled after it fired
+			return nil, nil, true
+		}
+		if chatEvent, ok := event.(*ChatEvent); ok {
+			// Chat works the same in every stage, the session relays it once the stage is idle
+			if nextStage, ok := forward(syncEvent); !ok {
+				return nextStage, event, false
+			}
+			s.handleChat(chatEvent, stage)
+			return nil, nil, true
+		}
+		if nextStage, ok := forward(event); !ok {
+			return nextStage, event, false
+		}
+		if _, ok := stage.(StageSnapshotter); !ok {
+			// There is no state to save, the next event waits for the stage on its own
+			return nil, nil, true
+		}
+		if nextStage, ok := forward(syncEvent); !ok {
+			return nextStage, nil, false
+		}
+		s.checkpoint(stage)
+		return nil, nil, true
+	}
+
+	if pending != nil {
+		if nextStage, unhandled, ok := handle(pending); !ok {
+			return nextStage, unhandled, result()
+		}
+	}
+
+	for {
+		select {
+		case event := <-s.PlayerEvents:
+			if nextStage, unhandled, ok := handle(event); !ok {
+				return nextStage, unhandled, result()
+			}
+		case request := <-s.stateRequests:
+			// Events queued before the request was made are handed over first so that the
+			// snapshot reflects them
+			for len(s.PlayerEvents) > 0 {
+				event := <-s.PlayerEvents
+				if nextStage, unhandled, ok := handle(event); !ok {
+					// The next stage has not started running yet so its state can be read safely
+					s.answer(request, nextStage)
+					return nextStage, unhandled, result()
+				}
+			}
+			// The stage may still be busy with something other than an event, e.g. when it
+			// has just started running
+			if nextStage, ok := forward(syncEvent); !ok {
+				s.answer(request, nextStage)
+				return nextStage, nil, result()
+			}
+			s.answer(request, stage)
+		case nextStage := <-next:
+			return nextStage, nil, stageErr
+		case <-s.ctx.Done():
+			return nil, nil, nil
+		}
+	}
+}
+
+// Log entry of a player event about to be handed to a stage. The entry is built beforehand so
+// that the event is numbered before any server event the stage sends in response to it.
+func (s *Session) logEntry(event PlayerEvent) *SessionEvent {
+	if s.db == nil || event == syncEvent {
+		return nil
+	}
+	source := PlayerEventSource
+	if event.Sender() == nil || event.Sender() == systemPlayer {
+		source = SystemEventSource
+	}
+	entry, err := newSessionEvent(s, s.nextSequence(), source, event)
+	if err != nil {
+		log.Printf(`Failed to encode event of type %s in session "%s": %s`, event.Type(), s.Code, err)
+		return nil
+	}
+	return entry
+}
+
+// Save a snapshot of the stage so that the session can be restored from it, this must only be
+// called while the stage is not processing an event. Stages that can't describe their state are
+// saved without a snapshot. Nothing is saved when the snapshot is the same as the last one.
+func (s *Session) checkpoint(stage StageRunner) {
+	if s.db == nil {
+		return
+	}
+
+	var name string
+	var state JSON
+	if snapshotter, ok := stage.(StageSnapshotter); ok {
+		encoded, err := json.Marshal(snapshotter.Snapshot())
+		if err != nil {
+			log.Printf(`Failed to save the state of session "%s": %s`, s.Code, err)
+			return
+		}
+		name, state = snapshotter.StageName(), encoded
+	}
+	// The session's fields hold the last snapshot that was saved, they are only read when the
+	// session is resumed, before its event loop starts
+	if name == s.StageName && bytes.Equal(state, s.StageState) {
+		return
+	}
+
+	err := s.db.Model(&Session{}).Where("id = ?", s.ID).Updates(map[string]interface{}{
+		"stage_name":  name,
+		"stage_state": state,
+	}).Error
+	if err != nil {
+		log.Printf(`Failed to save the state of session "%s": %s`, s.Code, err)
+		return
+	}
+	s.StageName, s.StageState = name, state
+}
+
+// Reply to a state request with a snapshot of the stage, which must not be processing an event
+func (s *Session) answer(request stateRequest, stage StageRunner) {
+	reply := s.snapshot(stage)
+	if reply.err == nil && request.spectator != nil {
+		s.addSpectator(request.spectator, reply.state)
+	}
+	request.reply <- reply
+}
+
+func (s *Session) snapshot(stage StageRunner) stateReply {
+	state := &SessionState{
+		Players:  s.players(),
+		Chat:     s.ChatHistory(),
+		sequence: s.lastSequence(),
+	}
+
+	if snapshotter, ok := stage.(StageSnapshotter); ok {
+		encoded, err := json.Marshal(snapshotter.Snapshot())
+		if err != nil {
+			return stateReply{err: err}
+		}
+		state.Stage = snapshotter.StageName()
+		state.State = encoded
+	}
+	if lobby, ok := stage.(LobbyStage); ok {
+		state.AcceptingPlayers = lobby.AcceptingPlayers()
+	} else {
+		state.AcceptingPlayers = s.CurrentStatus() == SessionLobby
+	}
+	return stateReply{state: state}
+}
+
+// Get a snapshot of the session's current stage and players. The snapshot is taken by the
+// session's event loop in between two events so it never races with the running stage.
+func (s *Session) State(ctx context.Context) (*SessionState, error) {
+	reply := make(chan stateReply, 1)
+	select {
+	case s.stateRequests <- stateRequest{reply: reply}:
+	case <-s.done:
+		return s.endedState(), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case r := <-reply:
+		return r.state, r.err
+	case <-s.done:
+		// The session ended before the request was answered
+		return s.endedState(), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// State of a session that has ended, there is no stage left to describe
+func (s *Session) endedState() *SessionState {
+	return &SessionState{Players: s.players(), Chat: s.ChatHistory(), sequence: s.lastSequence()}
+}
@@ -1,8 +1,11 @@
 package models
 
 import (
+	"context"
+	"errors"
 	"math"
 	"testing"
+	"time"
 )
 
 func predictableSeed() func() int64 {
@@ -41,8 +44,10 @@ func TestNewSession_CodeCollision(t *testing.T) {
 	db, cleanup := ConnectWithTestDB()
 	defer cleanup()
 
-	session1, _ := newSessionWithSeed(db, newGame(), predictableSeed())
-	session2, _ := newSessionWithSeed(db, newGame(), predictableSeed())
+	// Codes are only unique among live sessions
+	game := NewGame("TestGame", &countingStage{})
+	session1, _ := newSessionWithSeed(db, game, predictableSeed())
+	session2, _ := newSessionWithSeed(db, game, predictableSeed())
 
 	if session1.Code == session2.Code {
 		t.Errorf(`Both sessions were created with code collision "%s"`, session1.Code)
@@ -55,6 +60,25 @@ func TestNewSession_CodeCollision(t *testing.T) {
 	}
 }
 
+func TestSession_PlayerForID(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	session, _ := newSessionWithSeed(db, newGame(), predictableSeed())
+	player, _ := NewPlayer(db, "Mikey")
+	session.AddPlayer(player)
+
+	found, err := session.PlayerForID(player.ID)
+	if err != nil || found != player {
+		t.Errorf("PlayerForID() = %v, %v; expected %v", found, err, player)
+	}
+
+	_, err = session.PlayerForID(player.ID + 1)
+	if err == nil {
+		t.Errorf("PlayerForID() returned no error for an unknown player")
+	}
+}
+
 func Test_alphaSessionCode(t *testing.T) {
 	tests := []struct {
 		name string
@@ -84,3 +108,207 @@ type testStage struct{}
 func (s *testStage) Run(<-chan PlayerEvent) StageRunner {
 	return nil
 }
+
+func TestSession_State(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	session, _ := newSessionWithSeed(db, NewGame("TestGame", &countingStage{}), predictableSeed())
+	player, _ := NewPlayer(db, "Mikey")
+	session.AddPlayer(player)
+
+	for i := 0; i < 3; i++ {
+		session.HandlePlayerEvent(NewPlayerEvent(context.Background(), "COUNT", player))
+	}
+
+	state, err := session.State(context.Background())
+	if err != nil {
+		t.Fatalf("State() returned an error: %s", err)
+	}
+	if state.Stage != "counting" {
+		t.Errorf(`State() returned stage "%s"; expected "counting"`, state.Stage)
+	}
+	if string(state.State) != `{"count":3}` {
+		t.Errorf(`State() returned state %s; expected {"count":3}`, state.State)
+	}
+	if len(state.Players) != 1 || state.Players[0] != player {
+		t.Errorf("State() returned players %v; expected [%v]", state.Players, player)
+	}
+}
+
+func TestSession_State_Ended(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	session, _ := newSessionWithSeed(db, newGame(), predictableSeed())
+	select {
+	case <-session.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Session did not end after its only stage returned nil")
+	}
+
+	state, err := session.State(context.Background())
+	if err != nil {
+		t.Fatalf("State() returned an error: %s", err)
+	}
+	if state.Stage != "" || state.State != nil {
+		t.Errorf("State() returned stage %s with state %s; expected no stage", state.Stage, state.State)
+	}
+}
+
+func TestSession_HandlePlayerEvent_Ended(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	session, _ := newSessionWithSeed(db, newGame(), predictableSeed())
+	waitForDone(t, session)
+	player, _ := NewPlayer(db, "Mikey")
+
+	// More events than the session's buffer holds, none of them waits
+	for i := 0; i <= ChanBufferSize; i++ {
+		err := session.HandlePlayerEvent(NewPlayerEvent(context.Background(), "COUNT", player))
+		if !errors.Is(err, ErrSessionEnded) {
+			t.Fatalf("HandlePlayerEvent() returned %v; expected ErrSessionEnded", err)
+		}
+	}
+}
+
+// Stage that doesn't read its events until it is released
+type blockedStage struct {
+	release chan struct{}
+}
+
+func (s *blockedStage) Run(<-chan PlayerEvent) StageRunner {
+	<-s.release
+	return nil
+}
+
+func TestSession_HandlePlayerEvent_Cancelled(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	stage := &blockedStage{release: make(chan struct{})}
+	defer close(stage.release)
+	session, _ := newSessionWithSeed(db, NewGame("TestGame", stage), predictableSeed())
+	player, _ := NewPlayer(db, "Mikey")
+
+	// Events are buffered until the buffer is full, then the sender gives up once its context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var err error
+	for i := 0; i <= 2*ChanBufferSize && err == nil; i++ {
+		err = session.HandlePlayerEvent(NewPlayerEvent(ctx, "COUNT", player))
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("HandlePlayerEvent() returned %v; expected context.Canceled", err)
+	}
+}
+
+func TestSession_Checkpoint(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	session, _ := newSessionWithSeed(db, NewGame("TestGame", &countingStage{}), predictableSeed())
+	player, _ := NewPlayer(db, "Mikey")
+	session.AddPlayer(player)
+	savedState := func(event EventType) string {
+		session.HandlePlayerEvent(NewPlayerEvent(context.Background(), event, player))
+		if _, err := session.State(context.Background()); err != nil {
+			t.Fatalf("State() returned an error: %s", err)
+		}
+		var saved Session
+		db.First(&saved, session.ID)
+		return string(saved.StageState)
+	}
+
+	if state := savedState("COUNT"); state != `{"count":1}` {
+		t.Errorf(`Saved state %s; expected {"count":1}`, state)
+	}
+
+	// The stage's state is not saved again until it changes
+	db.Model(&Session{}).Where("id = ?", session.ID).Update("stage_state", JSON(`{}`))
+	if state := savedState("PING"); state != `{}` {
+		t.Errorf(`Saved state %s after an event that changed nothing; expected it to be left as is`, state)
+	}
+	if state := savedState("COUNT"); state != `{"count":2}` {
+		t.Errorf(`Saved state %s; expected {"count":2}`, state)
+	}
+}
+
+type countingStage struct {
+	count int
+}
+
+func (s *countingStage) Run(events <-chan PlayerEvent) StageRunner {
+	for event := range events {
+		if event.Type() == "COUNT" {
+			s.count++
+		}
+	}
+	return nil
+}
+
+func (s *countingStage) StageName() string {
+	return "counting"
+}
+
+func (s *countingStage) Snapshot() interface{} {
+	return map[string]int{"count": s.count}
+}
+
+func TestSession_Status(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	release := make(chan struct{})
+	initial := &handOffStage{release: release, next: &testStage{}}
+	session, _ := newSessionWithSeed(db, NewGame("TestGame", initial), predictableSeed())
+	if status := session.CurrentStatus(); status != SessionLobby {
+		t.Errorf("New session has status %s; expected %s", status, SessionLobby)
+	}
+
+	close(release)
+	select {
+	case <-session.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Session did not end after its last stage returned nil")
+	}
+	if status := session.CurrentStatus(); status != SessionFinished {
+		t.Errorf("Ended session has status %s; expected %s", status, SessionFinished)
+	}
+
+	var saved Session
+	db.First(&saved, session.ID)
+	if saved.Status != SessionFinished {
+		t.Errorf("Ended session was saved with status %s; expected %s", saved.Status, SessionFinished)
+	}
+}
+
+func TestSession_Status_Running(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	initial := &handOffStage{release: make(chan struct{}), next: &countingStage{}}
+	close(initial.release)
+	session, _ := newSessionWithSeed(db, NewGame("TestGame", initial), predictableSeed())
+
+	deadline := time.After(time.Second)
+	for session.CurrentStatus() != SessionRunning {
+		select {
+		case <-deadline:
+			t.Fatalf("Session has status %s after its initial stage ended; expected %s", session.CurrentStatus(), SessionRunning)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// Stage that hands off to the next stage once it is released
+type handOffStage struct {
+	release chan struct{}
+	next    StageRunner
+}
+
+func (s *handOffStage) Run(<-chan PlayerEvent) StageRunner {
+	<-s.release
+	return s.next
+}
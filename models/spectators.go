@@ -0,0 +1,115 @@
+package models
+
+import (
+	"context"
+)
+
+const SnapshotEventType EventType = "SNAPSHOT"
+
+// Event that describes the session, e.g. the first event sent to a spectator. Its sequence is
+// that of the last event logged before the snapshot was taken.
+type SnapshotEvent struct {
+	ServerEvent
+
+	State *SessionState
+}
+
+func newSnapshotEvent(state *SessionState) *SnapshotEvent {
+	event := &SnapshotEvent{
+		ServerEvent: NewServerEvent(SnapshotEventType),
+		State:       state,
+	}
+	event.setSequence(state.sequence)
+	return event
+}
+
+// Describe the session in an event, e.g. for a client that missed too many events to catch up
+func (s *Session) Snapshot(ctx context.Context) (*SnapshotEvent, error) {
+	state, err := s.State(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newSnapshotEvent(state), nil
+}
+
+// Send a public event to players. The event is also published to the spectators of the players'
+// session, unlike events sent to a single player which are private.
+func Broadcast(players []*Player, event ServerEvent) {
+	for _, p := range players {
+		p.Send(event)
+	}
+	if len(players) > 0 && players[0].Session != nil {
+		players[0].Session.Publish(event)
+	}
+}
+
+// Send a public event to the session's spectators. Spectators must not slow down the game so a
+// spectator that isn't keeping up with the events is disconnected, its channel is closed.
+func (s *Session) Publish(event ServerEvent) {
+	s.stamp(event)
+
+	s.spectatorsMu.Lock()
+	defer s.spectatorsMu.Unlock()
+
+	for spectator := range s.spectators {
+		select {
+		case spectator <- event:
+		default:
+			delete(s.spectators, spectator)
+			close(spectator)
+		}
+	}
+}
+
+// Watch the session without joining it. The first event on the returned channel is a
+// SnapshotEvent that describes the session, it is followed by every public event sent after the
+// snapshot was taken. The channel is closed once the context or the session is done.
+func (s *Session) Spectate(ctx context.Context) (<-chan ServerEvent, error) {
+	spectator := make(chan ServerEvent, ChanBufferSize)
+	reply := make(chan stateReply, 1)
+	select {
+	case s.stateRequests <- stateRequest{reply: reply, spectator: spectator}:
+	case <-s.done:
+		// The session has ended, there is nothing left to watch but how it ended
+		spectator <- newSnapshotEvent(&SessionState{Players: s.players(), Chat: s.ChatHistory(), sequence: s.lastSequence()})
+		close(spectator)
+		return spectator, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	// A request that was accepted is always answered. The answer is awaited even if the context
+	// is done so that the spectator is never registered after it was removed.
+	if r := <-reply; r.err != nil {
+		return nil, r.err
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.done:
+		}
+		s.removeSpectator(spectator)
+	}()
+	return spectator, nil
+}
+
+// Register a spectator while the session's stage is not processing an event, the snapshot is
+// queued before any event published afterwards
+func (s *Session) addSpectator(spectator chan ServerEvent, state *SessionState) {
+	s.spectatorsMu.Lock()
+	defer s.spectatorsMu.Unlock()
+
+	spectator <- newSnapshotEvent(state)
+	s.spectators[spectator] = struct{}{}
+}
+
+func (s *Session) removeSpectator(spectator chan ServerEvent) {
+	s.spectatorsMu.Lock()
+	defer s.spectatorsMu.Unlock()
+
+	if _, ok := s.spectators[spectator]; ok {
+		delete(s.spectators, spectator)
+		close(spectator)
+	}
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func receive(t *testing.T, events <-chan ServerEvent) (ServerEvent, bool) {
+	select {
+	case event, open := <-events:
+		return event, open
+	case <-time.After(500 * time.Millisecond):
+		require.Fail(t, "Timeout", "Did not receive expected server event before timeout")
+		return nil, false
+	}
+}
+
+func TestSession_Spectate(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	session, _ := newSessionWithSeed(db, NewGame("TestGame", &countingStage{}), predictableSeed())
+	player, _ := NewPlayer(db, "Mikey")
+	session.AddPlayer(player)
+	session.HandlePlayerEvent(NewPlayerEvent(context.Background(), "COUNT", player))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := session.Spectate(ctx)
+	require.Nil(t, err)
+
+	event, _ := receive(t, events)
+	require.IsType(t, &SnapshotEvent{}, event)
+	snapshot := event.(*SnapshotEvent)
+	assert.Equal(t, SnapshotEventType, snapshot.Type())
+	assert.Equal(t, uint(1), snapshot.Sequence())
+	assert.Equal(t, "counting", snapshot.State.Stage)
+	assert.JSONEq(t, `{"count": 1}`, string(snapshot.State.State))
+
+	player.Send(NewErrorEvent(fmt.Errorf("private")))
+	public := NewServerEvent("PUBLIC")
+	Broadcast([]*Player{player}, public)
+
+	event, _ = receive(t, events)
+	assert.Equal(t, public, event, "Spectators should only receive public events")
+	assert.Equal(t, uint(3), event.Sequence())
+
+	cancel()
+	_, open := receive(t, events)
+	assert.False(t, open, "Spectator channel was not closed after the context was done")
+}
+
+func TestSession_Spectate_SlowSpectator(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	session, _ := newSessionWithSeed(db, NewGame("TestGame", &countingStage{}), predictableSeed())
+	events, err := session.Spectate(context.Background())
+	require.Nil(t, err)
+
+	// The snapshot takes one spot in the spectator's buffer
+	for i := 0; i < ChanBufferSize; i++ {
+		session.Publish(NewServerEvent("PUBLIC"))
+	}
+
+	count := 0
+	for range events {
+		count++
+	}
+	assert.Equal(t, ChanBufferSize, count, "Spectator should have been disconnected once its buffer was full")
+}
+
+func TestSession_Spectate_Ended(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	session, _ := newSessionWithSeed(db, newGame(), predictableSeed())
+	<-session.Done()
+
+	events, err := session.Spectate(context.Background())
+	require.Nil(t, err)
+
+	event, _ := receive(t, events)
+	assert.IsType(t, &SnapshotEvent{}, event)
+	_, open := receive(t, events)
+	assert.False(t, open)
+}
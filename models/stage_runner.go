@@ -1,5 +1,10 @@
 package models
 
+import (
+	"context"
+	"runtime/debug"
+)
+
 // An interface for the code logic for each game's stage. These can be thought of
 // as a "state" in the game's rules-based finite state machine. The game engine will
 // execute the `Run()` method once the game enters a stage.
@@ -12,6 +17,68 @@ package models
 // argument. It should then act on each event accordingly. The runner can delegate
 // the game execution to another stage by ending the `Run()` and returning the
 // next `StageRunner` that will take over.
+//
+// Events of a type that the runner doesn't recognize must be ignored, the session uses
+// them to synchronize with the runner. Their sender is never nil, replying to it is safe.
 type StageRunner interface {
 	Run(<-chan PlayerEvent) StageRunner
 }
+
+// Version of `StageRunner` that runs with the session's context. The context is cancelled when
+// the session is aborted, the runner should then return promptly. An error returned by the
+// runner ends the session and is reported to its participants.
+//
+// The session prefers `RunContext()` over `Run()`, which runners can implement by calling
+// `RunContext()` with a background context.
+type ContextStageRunner interface {
+	StageRunner
+	RunContext(ctx context.Context, events <-chan PlayerEvent) (StageRunner, error)
+}
+
+// Adapt a `StageRunner` to the `ContextStageRunner` interface. The adapted runner is not told
+// when the session is aborted, its event channel is closed instead so its `Run()` must return
+// once the channel is closed.
+func AdaptStageRunner(stage StageRunner) ContextStageRunner {
+	if runner, ok := stage.(ContextStageRunner); ok {
+		return runner
+	}
+	return stageRunnerAdapter{stage}
+}
+
+type stageRunnerAdapter struct {
+	StageRunner
+}
+
+func (a stageRunnerAdapter) RunContext(ctx context.Context, events <-chan PlayerEvent) (StageRunner, error) {
+	next := make(chan StageRunner, 1)
+	// A panic after the session was aborted is dropped, there is no one left to report it to
+	panicked := make(chan *stagePanic, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				panicked <- &stagePanic{value: r, stack: debug.Stack()}
+			}
+		}()
+		next <- a.Run(events)
+	}()
+
+	select {
+	case stage := <-next:
+		return stage, nil
+	case p := <-panicked:
+		// Raised again from the caller's go routine so that the session can recover it
+		panic(p)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Optional interface for a `StageRunner` that can describe its current state to clients.
+// The value returned by `Snapshot()` must be serializable to JSON.
+//
+// The session only calls these methods from its event loop while the stage is waiting
+// for its next event, so they can safely read the stage's state without locking.
+type StageSnapshotter interface {
+	StageName() string
+	Snapshot() interface{}
+}
@@ -0,0 +1,187 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Stage that runs until its context is cancelled or it receives a FAIL event
+type contextStage struct {
+	stopped chan error
+}
+
+func (s *contextStage) Run(events <-chan PlayerEvent) StageRunner {
+	next, _ := s.RunContext(context.Background(), events)
+	return next
+}
+
+func (s *contextStage) RunContext(ctx context.Context, events <-chan PlayerEvent) (StageRunner, error) {
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// The session stopped, its context is cancelled by then
+				s.stopped <- ctx.Err()
+				return nil, ctx.Err()
+			}
+			if event.Type() == "FAIL" {
+				return nil, fmt.Errorf("stage failed")
+			}
+		case <-ctx.Done():
+			s.stopped <- ctx.Err()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func waitForDone(t *testing.T, session *Session) {
+	select {
+	case <-session.Done():
+	case <-time.After(time.Second):
+		require.Fail(t, "Timeout", "Session did not end")
+	}
+}
+
+func TestSession_Abort(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	stage := &contextStage{stopped: make(chan error, 1)}
+	session, _ := newSessionWithSeed(db, NewGame("TestGame", stage), predictableSeed())
+	player, _ := NewPlayer(db, "Mikey")
+	session.AddPlayer(player)
+	spectator, err := session.Spectate(context.Background())
+	require.Nil(t, err)
+	receive(t, spectator) // Snapshot
+
+	require.Nil(t, session.Abort("Server is shutting down"))
+	assert.ErrorIs(t, <-stage.stopped, context.Canceled)
+	waitForDone(t, session)
+	assert.Equal(t, SessionAborted, session.CurrentStatus())
+
+	event, _ := receive(t, player.ServerEvents)
+	require.IsType(t, &SessionAbortedEvent{}, event)
+	assert.Equal(t, "Server is shutting down", event.(*SessionAbortedEvent).Reason)
+	event, _ = receive(t, spectator)
+	assert.IsType(t, &SessionAbortedEvent{}, event)
+
+	assert.ErrorContains(t, session.Abort("again"), "has already ended")
+}
+
+func TestSession_Abort_LegacyStage(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	session, _ := newSessionWithSeed(db, NewGame("TestGame", &countingStage{}), predictableSeed())
+	require.Nil(t, session.Abort("Cancelled by host"))
+	waitForDone(t, session)
+	assert.Equal(t, SessionAborted, session.CurrentStatus())
+
+	var saved Session
+	db.First(&saved, session.ID)
+	assert.Equal(t, SessionAborted, saved.Status)
+}
+
+// Stage that holds on to the first event it receives until it is released, then fails
+type holdingStage struct {
+	holding chan struct{}
+	release chan struct{}
+}
+
+func (s *holdingStage) Run(events <-chan PlayerEvent) StageRunner {
+	next, _ := s.RunContext(context.Background(), events)
+	return next
+}
+
+func (s *holdingStage) RunContext(ctx context.Context, events <-chan PlayerEvent) (StageRunner, error) {
+	<-events
+	close(s.holding)
+	<-s.release
+	return nil, fmt.Errorf("stage failed")
+}
+
+func TestSession_Abort_BusyStage(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	stage := &holdingStage{holding: make(chan struct{}), release: make(chan struct{})}
+	session, _ := newSessionWithSeed(db, NewGame("TestGame", stage), predictableSeed())
+	player, _ := NewPlayer(db, "Mikey")
+	session.AddPlayer(player)
+	session.HandlePlayerEvent(NewPlayerEvent(context.Background(), "HOLD", player))
+	<-stage.holding
+	session.HandlePlayerEvent(NewPlayerEvent(context.Background(), "WAIT", player))
+
+	// The stage fails while the session is stopped
+	close(stage.release)
+	require.Nil(t, session.Abort("Cancelled by host"))
+	waitForDone(t, session)
+	assert.Equal(t, SessionAborted, session.CurrentStatus())
+}
+
+func TestSession_StageError(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	session, _ := newSessionWithSeed(db, NewGame("TestGame", &contextStage{}), predictableSeed())
+	player, _ := NewPlayer(db, "Mikey")
+	session.AddPlayer(player)
+	session.HandlePlayerEvent(NewPlayerEvent(context.Background(), "FAIL", player))
+	waitForDone(t, session)
+
+	assert.Equal(t, SessionCrashed, session.CurrentStatus())
+	event, _ := receive(t, player.ServerEvents)
+	require.IsType(t, &ErrorEvent{}, event)
+	assert.Equal(t, "stage failed", event.(*ErrorEvent).Message())
+}
+
+func TestAdaptStageRunner(t *testing.T) {
+	stage := &contextStage{}
+	assert.Same(t, stage, AdaptStageRunner(stage), "Runners that accept a context should not be wrapped")
+
+	events := make(chan PlayerEvent)
+	close(events)
+	next, err := AdaptStageRunner(&countingStage{}).RunContext(context.Background(), events)
+	assert.Nil(t, next)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = AdaptStageRunner(&handOffStage{release: make(chan struct{})}).RunContext(ctx, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// Stage that replies to every event, like stages that report the events they don't recognize
+type replyingStage struct{}
+
+func (s *replyingStage) Run(events <-chan PlayerEvent) StageRunner {
+	for event := range events {
+		event.Sender().Send(NewErrorEvent(fmt.Errorf("unknown event type: %s", event.Type())))
+	}
+	return nil
+}
+
+func TestSession_ReplyingStage(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	session, _ := newSessionWithSeed(db, NewGame("TestGame", &replyingStage{}), predictableSeed())
+	player, _ := NewPlayer(db, "Mikey")
+	session.AddPlayer(player)
+
+	// The session synchronizes with the stage after the event and to take the snapshot
+	session.HandlePlayerEvent(NewPlayerEvent(context.Background(), "PING", player))
+	_, err := session.State(context.Background())
+	require.Nil(t, err)
+
+	event, _ := receive(t, player.ServerEvents)
+	require.IsType(t, &ErrorEvent{}, event)
+	assert.Equal(t, "unknown event type: PING", event.(*ErrorEvent).Message())
+	assert.Empty(t, player.ServerEvents, "Replies to the session's own events should go nowhere")
+	assert.Equal(t, SessionLobby, session.CurrentStatus())
+}
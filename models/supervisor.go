@@ -0,0 +1,46 @@
+package models
+
+import (
+	"errors"
+	"log"
+	"runtime/debug"
+)
+
+// Error sent to the participants of a session whose game panicked. The details of the panic are
+// only logged, they may not be suitable for players.
+var ErrGameCrashed = errors.New("the game crashed unexpectedly")
+
+// Panic recovered from another go routine, raised again with the stack of where it happened
+type stagePanic struct {
+	value interface{}
+	stack []byte
+}
+
+// Recover a panic of the session's event loop, the session is marked as crashed instead of
+// taking down the whole server. Must be deferred by the event loop.
+func (s *Session) supervise() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	s.timers.cancelAll()
+	s.crash(s.recovered(r))
+}
+
+// Log a recovered panic along with the stack of the go routine that panicked. Must be called
+// from the deferred function that recovered it.
+func (s *Session) recovered(r interface{}) error {
+	if p, ok := r.(*stagePanic); ok {
+		log.Printf("Session \"%s\" panicked: %v\n%s", s.Code, p.value, p.stack)
+		return ErrGameCrashed
+	}
+	log.Printf("Session \"%s\" panicked: %v\n%s", s.Code, r, debug.Stack())
+	return ErrGameCrashed
+}
+
+// Mark the session as crashed and let its participants know
+func (s *Session) crash(err error) {
+	log.Printf(`Session "%s" crashed: %s`, s.Code, err)
+	s.setStatus(SessionCrashed)
+	s.Notify(NewErrorEvent(err))
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Stage that panics when it receives a PANIC event
+type panickingStage struct {
+	countingStage
+}
+
+func (s *panickingStage) Run(events <-chan PlayerEvent) StageRunner {
+	for event := range events {
+		if event.Type() == "PANIC" {
+			panic("boom")
+		}
+	}
+	return nil
+}
+
+// Stage whose snapshot panics, snapshots are taken by the session's event loop
+type panickingSnapshotStage struct {
+	countingStage
+}
+
+func (s *panickingSnapshotStage) Snapshot() interface{} {
+	panic("boom")
+}
+
+func TestSession_Supervise_StagePanic(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	session, _ := newSessionWithSeed(db, NewGame("TestGame", &panickingStage{}), predictableSeed())
+	player, _ := NewPlayer(db, "Mikey")
+	session.AddPlayer(player)
+	session.HandlePlayerEvent(NewPlayerEvent(context.Background(), "PANIC", player))
+	waitForDone(t, session)
+
+	assert.Equal(t, SessionCrashed, session.CurrentStatus())
+	event, _ := receive(t, player.ServerEvents)
+	require.IsType(t, &ErrorEvent{}, event)
+	assert.Equal(t, ErrGameCrashed, event.(*ErrorEvent).Error)
+
+	var saved Session
+	db.First(&saved, session.ID)
+	assert.Equal(t, SessionCrashed, saved.Status)
+}
+
+func TestSession_Supervise_LoopPanic(t *testing.T) {
+	db, cleanup := ConnectWithTestDB()
+	defer cleanup()
+
+	session, _ := newSessionWithSeed(db, NewGame("TestGame", &panickingSnapshotStage{}), predictableSeed())
+	waitForDone(t, session)
+	assert.Equal(t, SessionCrashed, session.CurrentStatus())
+
+	other, _ := newSessionWithSeed(db, NewGame("TestGame", &countingStage{}), predictableSeed())
+	_, err := other.State(context.Background())
+	assert.Nil(t, err, "Other sessions should keep running")
+}
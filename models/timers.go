@@ -0,0 +1,197 @@
+package models
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const TimerEventType EventType = "TIMER"
+
+// Event received by a stage when one of its timers fires. Timer events go through the same
+// stream as player events, they are sent by the session itself so replies to their sender go
+// nowhere.
+type TimerEvent struct {
+	PlayerEvent
+
+	Name string
+	id   uint64
+}
+
+// Timers of a session, stages use them to act after some time has passed, e.g. when a player
+// takes too long to play. A timer that fires is delivered to the stage as a TimerEvent.
+type Timers interface {
+	// Schedule a timer, a timer with the same name that is already scheduled is replaced
+	Schedule(name string, after time.Duration)
+	// Cancel a timer, returns false if no timer with that name is scheduled
+	Cancel(name string) bool
+	// Time left before a timer fires, returns false if no timer with that name is scheduled
+	Remaining(name string) (time.Duration, bool)
+}
+
+// Optional interface for a `StageRunner` that uses timers. The session hands the stage its
+// timers before running it. The timers that are still scheduled when the stage ends are
+// cancelled.
+type TimedStage interface {
+	UseTimers(timers Timers)
+}
+
+type sessionTimers struct {
+	session *Session
+	clock   Clock
+
+	mu        sync.Mutex
+	scheduled map[string]*scheduledTimer
+	lastID    uint64
+}
+
+type scheduledTimer struct {
+	id       uint64
+	timer    Timer
+	deadline time.Time
+}
+
+func newSessionTimers(session *Session, clock Clock) *sessionTimers {
+	return &sessionTimers{
+		session:   session,
+		clock:     clock,
+		scheduled: make(map[string]*scheduledTimer),
+	}
+}
+
+func (t *sessionTimers) Schedule(name string, after time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if existing, ok := t.scheduled[name]; ok {
+		existing.timer.Stop()
+	}
+	t.lastID++
+	event := &TimerEvent{
+		PlayerEvent: NewPlayerEvent(context.Background(), TimerEventType, systemPlayer),
+		Name:        name,
+		id:          t.lastID,
+	}
+	t.scheduled[name] = &scheduledTimer{
+		id:       event.id,
+		deadline: t.clock.Now().Add(after),
+		timer: t.clock.AfterFunc(after, func() {
+			// Nothing receives the event once the session has ended
+			select {
+			case t.session.PlayerEvents <- event:
+			case <-t.session.done:
+			}
+		}),
+	}
+}
+
+func (t *sessionTimers) Cancel(name string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	existing, ok := t.scheduled[name]
+	if !ok {
+		return false
+	}
+	existing.timer.Stop()
+	delete(t.scheduled, name)
+	return true
+}
+
+func (t *sessionTimers) Remaining(name string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	existing, ok := t.scheduled[name]
+	if !ok {
+		return 0, false
+	}
+	if remaining := existing.deadline.Sub(t.clock.Now()); remaining > 0 {
+		return remaining, true
+	}
+	// The timer has fired but the stage has not received its event yet
+	return 0, true
+}
+
+// Consume the timer that sent the event. Returns false if the timer was cancelled or replaced
+// after it fired, in which case the event must not reach the stage.
+func (t *sessionTimers) expire(event *TimerEvent) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	existing, ok := t.scheduled[event.Name]
+	if !ok || existing.id != event.id {
+		return false
+	}
+	delete(t.scheduled, event.Name)
+	return true
+}
+
+func (t *sessionTimers) cancelAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for name, existing := range t.scheduled {
+		existing.timer.Stop()
+		delete(t.scheduled, name)
+	}
+}
+
+// Timers that only keep track of what is scheduled, for testing stages without running a
+// session. Time does not pass for them, tests deliver the events of their timers with Fire().
+type FakeTimers struct {
+	mu        sync.Mutex
+	scheduled map[string]time.Duration
+}
+
+func NewFakeTimers() *FakeTimers {
+	return &FakeTimers{scheduled: make(map[string]time.Duration)}
+}
+
+func (t *FakeTimers) Schedule(name string, after time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.scheduled[name] = after
+}
+
+func (t *FakeTimers) Cancel(name string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.scheduled[name]
+	delete(t.scheduled, name)
+	return ok
+}
+
+// The time a timer was scheduled for, since time does not pass
+func (t *FakeTimers) Remaining(name string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	after, ok := t.scheduled[name]
+	return after, ok
+}
+
+// The scheduled timers and the time they were scheduled for, by name
+func (t *FakeTimers) Scheduled() map[string]time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	scheduled := make(map[string]time.Duration, len(t.scheduled))
+	for name, after := range t.scheduled {
+		scheduled[name] = after
+	}
+	return scheduled
+}
+
+// Consume a timer as if it had fired, returns the event to hand to the stage
+func (t *FakeTimers) Fire(name string) *TimerEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.scheduled, name)
+	return &TimerEvent{
+		PlayerEvent: NewPlayerEvent(context.Background(), TimerEventType, systemPlayer),
+		Name:        name,
+	}
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Stage that schedules timers when it starts and reports those that fire
+type timedStage struct {
+	timers   Timers
+	schedule map[string]time.Duration
+	fired    chan string
+	// The stage waits for it when it receives a HOLD event
+	hold chan struct{}
+}
+
+func (s *timedStage) UseTimers(timers Timers) {
+	s.timers = timers
+}
+
+func (s *timedStage) Run(events <-chan PlayerEvent) StageRunner {
+	for name, after := range s.schedule {
+		s.timers.Schedule(name, after)
+	}
+	s.fired <- "" // Timers are scheduled
+
+	for event := range events {
+		switch event := event.(type) {
+		case *TimerEvent:
+			if event.Sender() != systemPlayer {
+				panic("timer events are sent by the session")
+			}
+			s.fired <- event.Name
+		default:
+			if event.Type() == "HOLD" {
+				s.fired <- "" // Holding
+				<-s.hold
+			}
+		}
+	}
+	return nil
+}
+
+func newTimedSession(t *testing.T, schedule map[string]time.Duration) (*Session, *timedStage, *FakeClock, func()) {
+	db, cleanup := ConnectWithTestDB()
+	clock := NewFakeClock(time.Now())
+	stage := &timedStage{schedule: schedule, fired: make(chan string, 10), hold: make(chan struct{})}
+
+	session, err := createSession(db, NewGame("TestGame", stage), seededCodeGenerator{predictableSeed()}, clock)
+	require.Nil(t, err)
+	<-stage.fired
+	return session, stage, clock, cleanup
+}
+
+func waitForTimer(t *testing.T, stage *timedStage) string {
+	select {
+	case name := <-stage.fired:
+		return name
+	case <-time.After(500 * time.Millisecond):
+		require.Fail(t, "Timeout", "Timer did not fire")
+		return ""
+	}
+}
+
+func TestSession_Timers(t *testing.T) {
+	_, stage, clock, cleanup := newTimedSession(t, map[string]time.Duration{
+		"short": 10 * time.Second,
+		"long":  time.Minute,
+	})
+	defer cleanup()
+
+	clock.Advance(9 * time.Second)
+	select {
+	case name := <-stage.fired:
+		assert.Fail(t, "Timer fired too early", "Timer: %s", name)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(time.Second)
+	assert.Equal(t, "short", waitForTimer(t, stage))
+	clock.Advance(time.Minute)
+	assert.Equal(t, "long", waitForTimer(t, stage))
+}
+
+func TestSession_Timers_Cancel(t *testing.T) {
+	session, stage, clock, cleanup := newTimedSession(t, map[string]time.Duration{
+		"short": 10 * time.Second,
+		"long":  time.Minute,
+	})
+	defer cleanup()
+
+	// The timer fires while the stage is busy and is cancelled before the stage gets the event
+	session.HandlePlayerEvent(NewPlayerEvent(context.Background(), "HOLD", nil))
+	waitForTimer(t, stage)
+	clock.Advance(10 * time.Second)
+	assert.True(t, stage.timers.Cancel("short"))
+	close(stage.hold)
+
+	clock.Advance(time.Minute)
+	assert.Equal(t, "long", waitForTimer(t, stage), "Cancelled timer should not reach the stage")
+}
+
+func TestSession_Timers_Replace(t *testing.T) {
+	_, stage, clock, cleanup := newTimedSession(t, nil)
+	defer cleanup()
+
+	stage.timers.Schedule("turn", 10*time.Second)
+	clock.Advance(5 * time.Second)
+	stage.timers.Schedule("turn", 10*time.Second)
+	clock.Advance(5 * time.Second)
+	select {
+	case <-stage.fired:
+		assert.Fail(t, "Replaced timer should not fire")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(5 * time.Second)
+	assert.Equal(t, "turn", waitForTimer(t, stage))
+}
+
+func TestSession_Timers_Remaining(t *testing.T) {
+	session, stage, clock, cleanup := newTimedSession(t, map[string]time.Duration{"turn": time.Minute})
+	defer cleanup()
+
+	clock.Advance(20 * time.Second)
+	remaining, ok := stage.timers.Remaining("turn")
+	assert.True(t, ok)
+	assert.Equal(t, 40*time.Second, remaining)
+	_, ok = stage.timers.Remaining("other")
+	assert.False(t, ok)
+
+	// The timer's event is sent by the session itself
+	session.HandlePlayerEvent(NewPlayerEvent(context.Background(), "HOLD", nil))
+	waitForTimer(t, stage)
+	clock.Advance(40 * time.Second)
+	remaining, ok = stage.timers.Remaining("turn")
+	assert.True(t, ok, "The timer is scheduled until the stage gets its event")
+	assert.Zero(t, remaining)
+	close(stage.hold)
+	assert.Equal(t, "turn", waitForTimer(t, stage))
+	_, ok = stage.timers.Remaining("turn")
+	assert.False(t, ok)
+}
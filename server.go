@@ -1,19 +1,30 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/99designs/gqlgen/graphql/handler"
 	"github.com/99designs/gqlgen/graphql/playground"
 	"github.com/gin-gonic/gin"
 	"github.com/sebmartin/collabd/game"
 	"github.com/sebmartin/collabd/games/connect4"
 	"github.com/sebmartin/collabd/graph"
-	"github.com/sebmartin/collabd/graph/generated"
 )
 
-const defaultPort = "8080"
+const (
+	defaultPort = "8080"
+	// Time given to running games to end once the server is asked to stop, the games that are
+	// still running are resumed when the server restarts
+	defaultShutdownTimeout = 30 * time.Second
+	// Time given to in-flight HTTP requests once the games are stopped
+	httpShutdownTimeout = 5 * time.Second
+)
 
 // This is an example server that launches the game server with
 // a test game
@@ -23,28 +34,68 @@ func main() {
 		port = defaultPort
 	}
 
+	// Games are registered first so that the server can restore their sessions
+	connect4.Register()
+
 	srv, err := game.NewServer("sqlite", "db/models.sqlite")
 	if err != nil {
 		log.Fatalf("Failed to initalize game server: %s", err)
 	}
-
-	connect4.Register()
+	// Player tokens only remain valid across restarts when they are signed with the same secret
+	if secret := os.Getenv("TOKEN_SECRET"); secret != "" {
+		srv.TokenSecret = []byte(secret)
+	}
 
 	r := gin.Default()
 	r.POST("/query", graphqlHandler(srv))
+	r.GET("/query", graphqlHandler(srv))
 	r.GET("/", playgroundHandler())
 
-	log.Printf("connect to http://localhost:%s/ for GraphQL playground", port)
-	r.Run(":" + port)
+	shutdownTimeout := defaultShutdownTimeout
+	if timeout := os.Getenv("SHUTDOWN_TIMEOUT"); timeout != "" {
+		if shutdownTimeout, err = time.ParseDuration(timeout); err != nil {
+			log.Fatalf("Invalid SHUTDOWN_TIMEOUT: %s", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	httpServer := &http.Server{Addr: ":" + port, Handler: r}
+	go func() {
+		log.Printf("connect to http://localhost:%s/ for GraphQL playground", port)
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to serve HTTP: %s", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Printf("Shutting down, waiting up to %s for games to end", shutdownTimeout)
+
+	// Games are stopped first, players can keep playing over HTTP until then and their event
+	// streams are closed as the games end
+	gameCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(gameCtx); err != nil {
+		log.Printf("Some games were still running and were suspended: %s", err)
+	}
+
+	httpCtx, cancelHTTP := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancelHTTP()
+	if err := httpServer.Shutdown(httpCtx); err != nil {
+		log.Printf("Failed to shut down the HTTP server: %s", err)
+	}
+	if err := srv.Close(); err != nil {
+		log.Printf("Failed to close the database: %s", err)
+	}
 }
 
 func graphqlHandler(s *game.Server) gin.HandlerFunc {
-	srv := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{
-		Resolvers: &graph.Resolver{GameServer: s},
-	}))
+	h := graph.NewHandler(s)
 
 	return func(c *gin.Context) {
-		srv.ServeHTTP(c.Writer, c.Request)
+		h.ServeHTTP(c.Writer, c.Request)
 	}
 }
 